@@ -0,0 +1,106 @@
+// Command transfig-schema writes a JSON Schema for a config struct, so that editors can
+// offer autocomplete and validation while editing config files.
+//
+//	transfig-schema -type example.com/app/config.Config -o config.schema.json
+//	transfig-schema -type example.com/app/config.Config -env -o config.env.schema.json
+//
+// It must be run from inside the Go module that can import the config struct's package,
+// as it builds a small program there that calls transfig.Schema.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var program = template.Must(template.New("main").Parse(`package main
+
+import (
+	"os"
+
+	config "{{.Package}}"
+	"github.com/sironfoot/transfig"
+)
+
+func main() {
+	data, err := transfig.{{.Function}}(&config.{{.Type}}{})
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+
+	os.Stdout.Write(data)
+	os.Stdout.WriteString("\n")
+}
+`))
+
+func main() {
+	typeName := flag.String("type", "", "the config struct, as an import path and type name, e.g. example.com/app/config.Config")
+	environment := flag.Bool("env", false, "write the schema for environment config files, where every setting is optional")
+	output := flag.String("o", "", "the file to write the schema to, instead of stdout")
+	flag.Parse()
+
+	err := run(*typeName, *environment, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "transfig-schema:", err)
+		os.Exit(1)
+	}
+}
+
+func run(typeName string, environment bool, output string) error {
+	dot := strings.LastIndex(typeName, ".")
+	if dot <= 0 || dot == len(typeName)-1 || strings.LastIndex(typeName, "/") > dot {
+		return fmt.Errorf("-type must be an import path and type name, e.g. example.com/app/config.Config")
+	}
+
+	function := "Schema"
+	if environment {
+		function = "EnvironmentSchema"
+	}
+
+	var source bytes.Buffer
+	err := program.Execute(&source, map[string]string{
+		"Package":  typeName[:dot],
+		"Type":     typeName[dot+1:],
+		"Function": function,
+	})
+	if err != nil {
+		return err
+	}
+
+	// directories starting with an underscore are ignored by ./... patterns,
+	// so the program doesn't get picked up by builds running at the same time
+	dir, err := os.MkdirTemp(".", "_transfig-schema-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.WriteFile(filepath.Join(dir, "main.go"), source.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	var schema bytes.Buffer
+	cmd := exec.Command("go", "run", "./"+filepath.ToSlash(dir))
+	cmd.Stdout = &schema
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(schema.Bytes())
+		return err
+	}
+
+	return os.WriteFile(output, schema.Bytes(), 0644)
+}
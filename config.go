@@ -0,0 +1,154 @@
+package transfig
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// Config is a type-safe alternative to Load and LoadWithCaching. It holds a config
+// of type T, loaded from a primary config file and an environment config file
+// (or any list of sources), and reloads it when they change.
+//
+//	config := transfig.NewConfig[Configuration]("config.json", environment)
+//	settings, err := config.Load()
+type Config[T any] struct {
+	loader  *Loader
+	sources []Source
+
+	mu      sync.Mutex
+	current T
+
+	// stale is set when the config is evicted from its Loader's cache, so Get
+	// goes back to the Loader rather than returning snapshot forever
+	snapshot atomic.Pointer[T]
+	stale    atomic.Bool
+}
+
+// NewConfig returns a Config for the config file at path, with the environment
+// config file for environment applied over the top. As with Load, path may also
+// be a directory of config fragments.
+func NewConfig[T any](path, environment string) *Config[T] {
+	return NewConfigFS[T](osFS{}, path, environment)
+}
+
+// NewConfigFS is like NewConfig, but reads the config files from fsys.
+func NewConfigFS[T any](fsys fs.FS, path, environment string) *Config[T] {
+	return NewConfigSources[T](configSources(fsys, path, environment)...)
+}
+
+// NewConfigSources returns a Config loaded from an ordered list of sources,
+// the same way as LoadSources.
+func NewConfigSources[T any](sources ...Source) *Config[T] {
+	return &Config[T]{
+		loader:  defaultLoader,
+		sources: sources,
+	}
+}
+
+// WithLoader makes the Config load and cache its config using loader, rather than the
+// default Loader used by the package level functions. It must be called before the
+// config is first loaded, and returns the Config so it can be chained with NewConfig.
+func (c *Config[T]) WithLoader(loader *Loader) *Config[T] {
+	c.loader = loader
+	return c
+}
+
+// Load loads the config from its sources, and makes it the Current config.
+func (c *Config[T]) Load() (T, error) {
+	return c.get(context.Background(), true)
+}
+
+// LoadContext is like Load, but stops reading the sources once ctx is done.
+func (c *Config[T]) LoadContext(ctx context.Context) (T, error) {
+	return c.get(ctx, true)
+}
+
+// Current returns the current config, loading it the first time it's called. Its sources
+// are checked for changes every ReloadPollingInterval of its Loader, and the config is
+// reloaded if they have. If the config can't be reloaded, the last good config is returned,
+// or the zero value of T if it has never loaded. Each call returns its own copy of the config,
+// so it can be modified without affecting anyone else.
+func (c *Config[T]) Current() T {
+	current, _ := c.get(context.Background(), false)
+	return current
+}
+
+// Get returns the current config, like Current, but without locking or copying it, so it's
+// cheap enough to call on every request. The config it points to is shared, and must not be
+// modified. It is replaced, rather than changed, whenever the config is reloaded successfully.
+// Get returns nil if the config has never loaded. If the config was evicted, e.g. because its
+// primary config file was deleted or its Loader was closed, Get tries to load it again, like
+// Current, and returns the last good config until it can.
+func (c *Config[T]) Get() *T {
+	if snapshot := c.snapshot.Load(); snapshot != nil && !c.stale.Load() {
+		return snapshot
+	}
+
+	c.get(context.Background(), false)
+
+	return c.snapshot.Load()
+}
+
+func (c *Config[T]) configSources() []Source {
+	return c.sources
+}
+
+func (c *Config[T]) get(ctx context.Context, reload bool) (T, error) {
+	value, err := c.loader.cached(ctx, c, c.configSources, reload, func(ctx context.Context, sources []Source) (interface{}, error) {
+		var value T
+		err := loadSources(ctx, &value, sources, c.loader.Schema)
+		return value, err
+	}, func(configData interface{}) {
+		if configData == nil {
+			c.stale.Store(true)
+			return
+		}
+
+		// every config that's cached, including by background reloads, replaces the snapshot
+		snapshot := copyOf(configData.(T))
+		c.snapshot.Store(&snapshot)
+		c.stale.Store(false)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		if reload {
+			var zero T
+			return zero, err
+		}
+		return copyOf(c.current), err
+	}
+
+	c.current = value.(T)
+
+	return copyOf(c.current), nil
+}
+
+// OnReload calls fn whenever the config is reloaded in the background because its sources
+// changed, with the old and new config and the JSON path of every value that changed.
+// See Loader.Subscribe.
+func (c *Config[T]) OnReload(fn func(oldConfig, newConfig T, changed []string)) (unsubscribe func()) {
+	return c.loader.Subscribe(func(event ReloadEvent) {
+		if event.Key != c {
+			return
+		}
+
+		fn(event.Old.(T), event.New.(T), event.Changed)
+	})
+}
+
+// OnReloadError calls fn whenever the config can't be reloaded in the background, in which
+// case Current carries on returning the last good config. See Loader.SubscribeErrors.
+func (c *Config[T]) OnReloadError(fn func(err error)) (unsubscribe func()) {
+	return c.loader.SubscribeErrors(func(err *ReloadError) {
+		if err.Key != c {
+			return
+		}
+
+		fn(err.Err)
+	})
+}
@@ -0,0 +1,114 @@
+package transfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigMapSource returns a Source that reads a directory with one file per setting into
+// a JSON document, such as a Kubernetes ConfigMap or Secret mounted as a volume. Each file
+// name is the path to the setting it holds, with nested objects separated by "." (or by
+// subdirectories), and the file contents are its value. For example, a file called
+// "database.connectionString" sets the connectionString field of the database object.
+//
+// Values are strings, and are converted to the type of the field they are applied to.
+// Kubernetes updates mounted ConfigMaps by atomically swapping the "..data" symlink,
+// which is used as the Version, so updates are picked up even though file modification
+// times are left alone.
+func ConfigMapSource(dir string) Source {
+	return &configMap{
+		Dir: dir,
+	}
+}
+
+// configMapDataLink is the symlink Kubernetes swaps to update a mounted ConfigMap
+const configMapDataLink = "..data"
+
+type configMap struct {
+	Dir string
+}
+
+func (c *configMap) Read() ([]byte, error) {
+	tree := map[string]interface{}{}
+
+	err := c.walk(func(key []string, value []byte) {
+		setPath(tree, key, strings.TrimSuffix(string(value), "\n"))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(tree)
+}
+
+func (c *configMap) Version() (string, error) {
+	target, err := os.Readlink(filepath.Join(c.Dir, configMapDataLink))
+	if err == nil {
+		return configMapDataLink + ":" + target, nil
+	}
+
+	// not mounted by Kubernetes, so fall back to hashing everything
+	hash := sha256.New()
+	err = c.walk(func(key []string, value []byte) {
+		name := strings.Join(key, ".")
+		fmt.Fprintf(hash, "%d:%s%d:", len(name), name, len(value))
+		hash.Write(value)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// walk calls fn for every setting in the directory tree, in lexical order. Kubernetes'
+// own entries, which start with "..", are skipped.
+func (c *configMap) walk(fn func(key []string, value []byte)) error {
+	var walkDir func(dir string, parent []string) error
+	walkDir = func(dir string, parent []string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, "..") {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			key := append(append([]string{}, parent...), strings.Split(name, ".")...)
+
+			// keys are usually symlinks into ..data, so follow them
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				err = walkDir(path, key)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			value, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			fn(key, value)
+		}
+
+		return nil
+	}
+
+	return walkDir(c.Dir, nil)
+}
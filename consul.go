@@ -0,0 +1,119 @@
+package transfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulKV is a KVWatcher backed by the Consul HTTP API. Changes are
+// detected using Consul's blocking queries.
+type ConsulKV struct {
+	// Address of the Consul agent, e.g. "http://127.0.0.1:8500"
+	Address string
+
+	// Token, if set, is sent as the X-Consul-Token header
+	Token string
+
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// WaitTime is the longest a blocking query waits for a change
+	// before returning. If zero, 5 minutes is used.
+	WaitTime time.Duration
+}
+
+type consulPair struct {
+	Key   string
+	Value []byte
+}
+
+// List returns every key under prefix, with Consul's X-Consul-Index.
+func (c *ConsulKV) List(prefix string) ([]KVPair, uint64, error) {
+	return c.ListContext(context.Background(), prefix)
+}
+
+// ListContext is like List, but the request is cancelled once ctx is done.
+func (c *ConsulKV) ListContext(ctx context.Context, prefix string) ([]KVPair, uint64, error) {
+	return c.list(ctx, prefix, url.Values{})
+}
+
+// Wait performs a blocking query for changes to keys under prefix after index.
+func (c *ConsulKV) Wait(prefix string, index uint64, stop <-chan struct{}) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	waitTime := c.WaitTime
+	if waitTime == 0 {
+		waitTime = time.Duration(time.Minute * 5)
+	}
+
+	query := url.Values{}
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", fmt.Sprintf("%dms", waitTime.Milliseconds()))
+
+	_, newIndex, err := c.list(ctx, prefix, query)
+	return newIndex, err
+}
+
+func (c *ConsulKV) list(ctx context.Context, prefix string, query url.Values) ([]KVPair, uint64, error) {
+	query.Set("recurse", "true")
+	address := strings.TrimSuffix(c.Address, "/") + "/v1/kv/" + strings.TrimPrefix(prefix, "/") + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	index, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+
+	// Consul returns 404 when there are no keys under the prefix
+	if res.StatusCode == http.StatusNotFound {
+		return nil, index, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("GET %s: %s", address, res.Status)
+	}
+
+	var consulPairs []consulPair
+	err = json.NewDecoder(res.Body).Decode(&consulPairs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pairs := make([]KVPair, 0, len(consulPairs))
+	for _, pair := range consulPairs {
+		pairs = append(pairs, KVPair{Key: pair.Key, Value: pair.Value})
+	}
+
+	return pairs, index, nil
+}
@@ -0,0 +1,105 @@
+package transfig
+
+import (
+	"reflect"
+)
+
+// copyConfig returns a deep copy of a cached config, so callers can modify
+// the config they're given without affecting the cache, or each other.
+func copyConfig(configData interface{}) interface{} {
+	if configData == nil {
+		return nil
+	}
+
+	return deepCopy(reflect.ValueOf(configData)).Interface()
+}
+
+// copyOf is a typed copyConfig.
+func copyOf[T any](value T) T {
+	var copied T
+	reflect.ValueOf(&copied).Elem().Set(deepCopy(reflect.ValueOf(&value).Elem()))
+	return copied
+}
+
+// deepCopy returns a copy of value that shares no slices, maps or pointers with it.
+// Unexported struct fields can't be set using reflection, so they are copied as is.
+func deepCopy(value reflect.Value) reflect.Value {
+	c := copier{pointers: make(map[pointerKey]reflect.Value)}
+	return c.copy(value)
+}
+
+type pointerKey struct {
+	Type    reflect.Type
+	Pointer uintptr
+}
+
+type copier struct {
+	// pointers holds the copy of every pointer copied so far, so pointers to the
+	// same value still do after copying, and cyclic structures don't recurse forever
+	pointers map[pointerKey]reflect.Value
+}
+
+func (c copier) copy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+
+		key := pointerKey{value.Type(), value.Pointer()}
+		if copied, ok := c.pointers[key]; ok {
+			return copied
+		}
+
+		copied := reflect.New(value.Type().Elem())
+		c.pointers[key] = copied
+		copied.Elem().Set(c.copy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(c.copy(value.Elem()))
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(c.copy(value.Index(i)))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(c.copy(value.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), c.copy(iter.Value()))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(c.copy(value.Field(i)))
+			}
+		}
+		return copied
+	}
+
+	return value
+}
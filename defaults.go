@@ -0,0 +1,166 @@
+package transfig
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTagName is the struct tag holding a field's default value, which it is given
+// if the primary config file doesn't set it, e.g.
+//
+//	Timeout time.Duration `json:"timeout" default:"30s"`
+//	Port    int           `json:"port" default:"8080"`
+//	Hosts   []string      `json:"hosts" default:"a.example.com,b.example.com"`
+const defaultTagName = "default"
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// applyDefaults sets every field of configValue with a default tag to its default, before
+// the primary config is decoded over the top of it. Fields that already have a value, or
+// that are set by document, the decoded primary config, are left alone. Defaults are applied
+// to nested structs too, but not to the elements of slices or maps, as those are created by
+// decoding the config. A nested struct behind a nil pointer only gets its defaults if the
+// primary config sets it, so it isn't created just to hold them.
+func applyDefaults(configValue reflect.Value, document interface{}) error {
+	if configValue.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields, _ := document.(map[string]interface{})
+
+	for i := 0; i < configValue.NumField(); i++ {
+		fieldInfo := configValue.Type().Field(i)
+		fieldValue := configValue.Field(i)
+		if fieldInfo.PkgPath != "" {
+			continue
+		}
+
+		// encoding/json flattens embedded structs into their parent, and merges into maps
+		// that already exist, so a default map mustn't be set if the document sets it
+		var fieldDocument interface{} = fields
+		set := false
+		if !fieldInfo.Anonymous || fieldInfo.Tag.Get("json") != "" {
+			fieldDocument, set = lookupDocument(fields, []string{jsonFieldName(fieldInfo)}, true)
+			set = set && fieldDocument != nil
+		}
+
+		defaultText, hasDefault := fieldInfo.Tag.Lookup(defaultTagName)
+		if hasDefault && !set && fieldValue.IsZero() {
+			value, err := parseDefault(defaultText, fieldInfo.Type)
+			if err != nil {
+				return fmt.Errorf("config: invalid default for %s.%s: %s", configValue.Type(), fieldInfo.Name, err)
+			}
+
+			fieldValue.Set(value)
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Ptr {
+			if fieldValue.IsNil() {
+				if !set || fieldInfo.Type.Elem().Kind() != reflect.Struct {
+					continue
+				}
+
+				// encoding/json decodes into the struct a pointer already points to
+				fieldValue.Set(reflect.New(fieldInfo.Type.Elem()))
+			}
+			fieldValue = fieldValue.Elem()
+		}
+
+		err := applyDefaults(fieldValue, fieldDocument)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseDefault parses the text of a default tag into a value of valueType. Slices may be
+// written as comma separated values, and slices, maps and structs as JSON.
+func parseDefault(text string, valueType reflect.Type) (reflect.Value, error) {
+	value := reflect.New(valueType).Elem()
+
+	if valueType.Kind() == reflect.Ptr {
+		elem, err := parseDefault(text, valueType.Elem())
+		if err != nil {
+			return value, err
+		}
+
+		value.Set(reflect.New(valueType.Elem()))
+		value.Elem().Set(elem)
+		return value, nil
+	}
+
+	if reflect.PointerTo(valueType).Implements(textUnmarshalerType) {
+		err := value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+		return value, err
+	}
+
+	if valueType == durationType {
+		duration, err := time.ParseDuration(text)
+		value.SetInt(int64(duration))
+		return value, err
+	}
+
+	switch valueType.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return value, err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, valueType.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(text, 10, valueType.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, valueType.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(text), "[") {
+			err := json.Unmarshal([]byte(text), value.Addr().Interface())
+			return value, err
+		}
+
+		if text == "" {
+			value.Set(reflect.MakeSlice(valueType, 0, 0))
+			return value, nil
+		}
+
+		items := strings.Split(text, ",")
+		value.Set(reflect.MakeSlice(valueType, len(items), len(items)))
+		for i, item := range items {
+			elem, err := parseDefault(strings.TrimSpace(item), valueType.Elem())
+			if err != nil {
+				return value, err
+			}
+			value.Index(i).Set(elem)
+		}
+	case reflect.Map, reflect.Struct, reflect.Array:
+		err := json.Unmarshal([]byte(text), value.Addr().Interface())
+		return value, err
+	default:
+		return value, fmt.Errorf("defaults aren't supported for %s", valueType)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,101 @@
+package transfig
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// changedPaths compares two configs of the same type, returning the JSON path of every
+// value that differs. Slices that change length are reported as a whole, rather than
+// element by element.
+func changedPaths(oldConfig, newConfig interface{}) []string {
+	var changed []string
+	diffValues("", reflect.ValueOf(oldConfig), reflect.ValueOf(newConfig), &changed)
+	return changed
+}
+
+func diffValues(path string, oldValue, newValue reflect.Value, changed *[]string) {
+	if !oldValue.IsValid() || !newValue.IsValid() || oldValue.Type() != newValue.Type() {
+		if oldValue.IsValid() != newValue.IsValid() ||
+			(oldValue.IsValid() && !reflect.DeepEqual(oldValue.Interface(), newValue.Interface())) {
+			*changed = append(*changed, path)
+		}
+		return
+	}
+
+	switch oldValue.Kind() {
+	case reflect.Struct:
+		for i := 0; i < oldValue.NumField(); i++ {
+			fieldInfo := oldValue.Type().Field(i)
+			if fieldInfo.PkgPath != "" {
+				continue
+			}
+
+			name := jsonFieldName(fieldInfo)
+			if name == "-" {
+				continue
+			}
+
+			diffValues(joinPath(path, name), oldValue.Field(i), newValue.Field(i), changed)
+		}
+	case reflect.Slice, reflect.Array:
+		if oldValue.Len() != newValue.Len() {
+			*changed = append(*changed, path)
+			return
+		}
+
+		for i := 0; i < oldValue.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), oldValue.Index(i), newValue.Index(i), changed)
+		}
+	case reflect.Map:
+		keys := map[string]reflect.Value{}
+		for _, key := range oldValue.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+		for _, key := range newValue.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			key := keys[name]
+			diffValues(joinPath(path, name), oldValue.MapIndex(key), newValue.MapIndex(key), changed)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if oldValue.IsNil() || newValue.IsNil() {
+			if oldValue.IsNil() != newValue.IsNil() {
+				*changed = append(*changed, path)
+			}
+			return
+		}
+
+		diffValues(path, oldValue.Elem(), newValue.Elem(), changed)
+	default:
+		if !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			*changed = append(*changed, path)
+		}
+	}
+}
+
+// jsonFieldName returns the name encoding/json uses for a struct field
+func jsonFieldName(fieldInfo reflect.StructField) string {
+	name := strings.TrimSpace(strings.Split(fieldInfo.Tag.Get("json"), ",")[0])
+	if name == "" {
+		return fieldInfo.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,183 @@
+package transfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EtcdKV is a KVWatcher backed by the etcd v3 JSON gateway. Changes are
+// detected using etcd watches.
+type EtcdKV struct {
+	// Address of an etcd endpoint, e.g. "http://127.0.0.1:2379"
+	Address string
+
+	// Token, if set, is sent as the Authorization header
+	Token string
+
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader     `json:"header"`
+	Kvs    []etcdKeyValue `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Header          etcdHeader        `json:"header"`
+		Events          []json.RawMessage `json:"events"`
+		Canceled        bool              `json:"canceled"`
+		CancelReason    string            `json:"cancel_reason"`
+		CompactRevision string            `json:"compact_revision"`
+	} `json:"result"`
+}
+
+// List returns every key under prefix, with the etcd revision they were read at.
+func (e *EtcdKV) List(prefix string) ([]KVPair, uint64, error) {
+	return e.ListContext(context.Background(), prefix)
+}
+
+// ListContext is like List, but the request is cancelled once ctx is done.
+func (e *EtcdKV) ListContext(ctx context.Context, prefix string) ([]KVPair, uint64, error) {
+	body := map[string]interface{}{
+		"key":       []byte(prefix),
+		"range_end": prefixRangeEnd(prefix),
+	}
+
+	res, err := e.post(ctx, "/v3/kv/range", body)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	var rangeResponse etcdRangeResponse
+	err = json.NewDecoder(res.Body).Decode(&rangeResponse)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	revision, _ := strconv.ParseUint(rangeResponse.Header.Revision, 10, 64)
+
+	pairs := make([]KVPair, 0, len(rangeResponse.Kvs))
+	for _, kv := range rangeResponse.Kvs {
+		pairs = append(pairs, KVPair{Key: string(kv.Key), Value: kv.Value})
+	}
+
+	return pairs, revision, nil
+}
+
+// Wait watches keys under prefix for changes after revision index. If index has been
+// compacted away, the changes since can't be watched, so Wait returns the compacted
+// revision, which makes the keys be listed again and watched from their new revision.
+func (e *EtcdKV) Wait(prefix string, index uint64, stop <-chan struct{}) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	body := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(prefix),
+			"range_end":      prefixRangeEnd(prefix),
+			"start_revision": strconv.FormatUint(index+1, 10),
+		},
+	}
+
+	res, err := e.post(ctx, "/v3/watch", body)
+	if err != nil {
+		return index, err
+	}
+	defer res.Body.Close()
+
+	// the watch is a stream of responses, the first of which confirms it was created
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var watchResponse etcdWatchResponse
+		err = decoder.Decode(&watchResponse)
+		if err != nil {
+			return index, err
+		}
+
+		if watchResponse.Result.Canceled {
+			if watchResponse.Result.CompactRevision != "" {
+				return strconv.ParseUint(watchResponse.Result.CompactRevision, 10, 64)
+			}
+			return index, fmt.Errorf("config: etcd watch cancelled: %s", watchResponse.Result.CancelReason)
+		}
+
+		if len(watchResponse.Result.Events) > 0 {
+			return strconv.ParseUint(watchResponse.Result.Header.Revision, 10, 64)
+		}
+	}
+}
+
+func (e *EtcdKV) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	address := strings.TrimSuffix(e.Address, "/") + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.Token != "" {
+		req.Header.Set("Authorization", e.Token)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("POST %s: %s", address, res.Status)
+	}
+
+	return res, nil
+}
+
+// prefixRangeEnd returns the end of the etcd key range covering every key starting with prefix.
+func prefixRangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// every byte is 0xff, so the range runs to the end of the keyspace
+	return []byte{0}
+}
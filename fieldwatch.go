@@ -0,0 +1,144 @@
+package transfig
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// WatchField calls fn with the new value at path whenever a config cached by the package
+// level functions is reloaded, and the value at path (or anything beneath it) changed.
+// See WatchLoaderField.
+func WatchField[V any](path string, fn func(newValue V)) (unsubscribe func()) {
+	return WatchLoaderField(defaultLoader, path, fn)
+}
+
+// WatchLoaderField calls fn whenever a config cached by loader is reloaded in the background,
+// and the value at path, or anything beneath it, changed. path uses the JSON names of fields,
+// e.g. "logging.level" or "servers[0].host". fn is passed the new value converted to V, and
+// isn't called if the value can't be converted.
+func WatchLoaderField[V any](loader *Loader, path string, fn func(newValue V)) (unsubscribe func()) {
+	return loader.Subscribe(func(event ReloadEvent) {
+		notifyField(event, path, fn)
+	})
+}
+
+// WatchConfigField is like WatchLoaderField, but only watches the config held by config.
+func WatchConfigField[V any, T any](config *Config[T], path string, fn func(newValue V)) (unsubscribe func()) {
+	return config.loader.Subscribe(func(event ReloadEvent) {
+		if event.Key != config {
+			return
+		}
+
+		notifyField(event, path, fn)
+	})
+}
+
+func notifyField[V any](event ReloadEvent, path string, fn func(V)) {
+	oldValue, oldOK := lookupPath(reflect.ValueOf(event.Old), path)
+	newValue, newOK := lookupPath(reflect.ValueOf(event.New), path)
+
+	if !newOK {
+		return
+	}
+
+	if oldOK && reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+		return
+	}
+
+	value, ok := convertValue[V](newValue)
+	if !ok {
+		return
+	}
+
+	fn(value)
+}
+
+func convertValue[V any](value reflect.Value) (V, bool) {
+	var converted V
+
+	target := reflect.ValueOf(&converted).Elem()
+	switch {
+	case value.Type().AssignableTo(target.Type()):
+		target.Set(value)
+	case value.Type().ConvertibleTo(target.Type()) &&
+		(value.Kind() == target.Kind() || (isNumber(value.Kind()) && isNumber(target.Kind()))):
+		target.Set(value.Convert(target.Type()))
+	default:
+		return converted, false
+	}
+
+	return converted, true
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// lookupPath returns the value at a JSON path, e.g. "servers[0].host", within value.
+func lookupPath(value reflect.Value, path string) (reflect.Value, bool) {
+	for _, segment := range splitPath(path) {
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+
+		if index, err := strconv.Atoi(segment); err == nil && (value.Kind() == reflect.Slice || value.Kind() == reflect.Array) {
+			if index < 0 || index >= value.Len() {
+				return reflect.Value{}, false
+			}
+			value = value.Index(index)
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			found := false
+			for i := 0; i < value.NumField(); i++ {
+				fieldInfo := value.Type().Field(i)
+				if fieldInfo.PkgPath == "" && jsonFieldName(fieldInfo) == segment {
+					value = value.Field(i)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return reflect.Value{}, false
+			}
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}, false
+			}
+			value = value.MapIndex(reflect.ValueOf(segment).Convert(value.Type().Key()))
+			if !value.IsValid() {
+				return reflect.Value{}, false
+			}
+		default:
+			return reflect.Value{}, false
+		}
+	}
+
+	return value, value.IsValid()
+}
+
+// splitPath splits a JSON path such as "servers[0].host" into "servers", "0" and "host"
+func splitPath(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
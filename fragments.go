@@ -0,0 +1,209 @@
+package transfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configSources returns the primary and environment sources for configPath,
+// which is either a config file or a directory of config fragments.
+func configSources(fsys fs.FS, configPath, environment string) []Source {
+	info, err := fs.Stat(fsys, configPath)
+	if err == nil && info.IsDir() {
+		sources := []Source{FragmentsSource(fsys, configPath)}
+		if environment != "" {
+			sources = append(sources, FragmentsSource(fsys, path.Join(configPath, environment)))
+		}
+		return sources
+	}
+
+	return []Source{FSSource(fsys, configPath), FSSource(fsys, generateEnvPath(configPath, environment))}
+}
+
+// FragmentsSource returns a Source that merges every *.json file in the directory dir of
+// fsys, in lexical order (e.g. "10-db.json" then "20-email.json"). Objects are merged
+// field by field, and any other value in a later fragment replaces the earlier one.
+// Subdirectories are ignored. Its Version changes whenever a fragment is added,
+// removed or its contents change.
+func FragmentsSource(fsys fs.FS, dir string) Source {
+	return &fragmentsDir{
+		FS:  fsys,
+		Dir: dir,
+	}
+}
+
+type fragmentsDir struct {
+	FS  fs.FS
+	Dir string
+
+	mu       sync.Mutex
+	read     bool
+	includes []string
+
+	// the names, sizes and modification times the hash was taken at, and when
+	stat    string
+	modTime time.Time
+	hashed  time.Time
+	hash    string
+}
+
+// fragments returns the names of the fragments in the directory, in lexical order
+func (f *fragmentsDir) fragments() ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.FS, f.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fragments := entries[:0]
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			fragments = append(fragments, entry)
+		}
+	}
+
+	return fragments, nil
+}
+
+func (f *fragmentsDir) Read() ([]byte, error) {
+	// stat before reading, so a change made while we're reading is seen by the next Version
+	fragments, err := f.fragments()
+	if err != nil {
+		return nil, err
+	}
+	hashed := time.Now()
+
+	var includes []string
+	merged := map[string]interface{}{}
+	for _, fragment := range fragments {
+		fragmentPath := path.Join(f.Dir, fragment.Name())
+
+		data, err := fs.ReadFile(f.FS, fragmentPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasIncludes(data) {
+			var fragmentIncludes []string
+			data, fragmentIncludes, err = resolveIncludes(f.FS, fragmentPath, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fragmentPath, err)
+			}
+			includes = append(includes, fragmentIncludes...)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(stripComments(data)))
+		decoder.UseNumber()
+
+		fragmentData := map[string]interface{}{}
+		err = decoder.Decode(&fragmentData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal config fragment \"%s\": %s", fragmentPath, err)
+		}
+
+		mergeMaps(merged, fragmentData)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+	stat, modTime, statErr := f.fragmentsStat(fragments, includes)
+
+	f.mu.Lock()
+	f.read = true
+	f.includes = includes
+	f.hash = hex.EncodeToString(hash[:])
+	f.hashed = hashed
+	f.stat = ""
+	if statErr == nil {
+		f.stat, f.modTime = stat, modTime
+	}
+	f.mu.Unlock()
+
+	return data, nil
+}
+
+// Version is a hash of the merged fragments, including any files they include. As with a
+// config file, they're only read and hashed again if the fragments' names, sizes or
+// modification times, or those of their includes, have changed since they were last
+// hashed, or they were modified too recently to tell.
+func (f *fragmentsDir) Version() (string, error) {
+	fragments, err := f.fragments()
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	read, includes := f.read, f.includes
+	stat, modTime, hashed, hash := f.stat, f.modTime, f.hashed, f.hash
+	f.mu.Unlock()
+
+	if read && stat != "" && hashed.Sub(modTime) >= modTimeGranularity {
+		if current, _, err := f.fragmentsStat(fragments, includes); err == nil && current == stat {
+			return hash, nil
+		}
+	}
+
+	_, err = f.Read()
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.hash, nil
+}
+
+// fragmentsStat returns the names, sizes and modification times of the fragments and their
+// includes, as a cheap check for changes, along with the latest of their modification times.
+func (f *fragmentsDir) fragmentsStat(fragments []fs.DirEntry, includes []string) (string, time.Time, error) {
+	var latest time.Time
+
+	hash := sha256.New()
+	for _, fragment := range fragments {
+		info, err := fragment.Info()
+		if err != nil {
+			return "", latest, err
+		}
+
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", fragment.Name(), info.Size(), info.ModTime().UnixNano())
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	version, includesLatest := includesVersion(f.FS, includes)
+	hash.Write([]byte(version))
+	if includesLatest.After(latest) {
+		latest = includesLatest
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), latest, nil
+}
+
+// mergeMaps merges src into dst. Objects present in both are merged recursively,
+// anything else in src replaces what is in dst.
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+
+		dst[key] = srcValue
+	}
+}
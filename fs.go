@@ -0,0 +1,92 @@
+package transfig
+
+import (
+	"io/fs"
+	"os"
+	"reflect"
+)
+
+// osFS is an fs.FS backed directly by the operating system. Unlike os.DirFS
+// it accepts any path the os package does, including absolute and relative
+// paths, so Load and LoadWithCaching behave exactly as they always have.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// FileKey identifies a config cached by LoadWithCaching or LoadWithCachingFS, by
+// the file system it was read from, the primary config file path and the environment.
+type FileKey struct {
+	Path        string
+	Environment string
+	fs          interface{}
+}
+
+func newFileKey(fsys fs.FS, path, environment string) (FileKey, error) {
+	identity, ok := fsIdentity(fsys)
+	if !ok {
+		return FileKey{}, ErrFSNotCacheable
+	}
+
+	return FileKey{
+		Path:        path,
+		Environment: environment,
+		fs:          identity,
+	}, nil
+}
+
+// fsIdentity returns a comparable value identifying fsys. Some file systems,
+// such as fstest.MapFS, are maps and cannot be used as map keys directly, so
+// are identified by their pointer instead. Anything else can only be used if
+// hashing it is safe, i.e. it holds no maps, slices or funcs, otherwise ok is false.
+func fsIdentity(fsys fs.FS) (identity interface{}, ok bool) {
+	value := reflect.ValueOf(fsys)
+
+	switch value.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Slice, reflect.Func, reflect.Chan:
+		return struct {
+			Type    reflect.Type
+			Pointer uintptr
+		}{value.Type(), value.Pointer()}, true
+	}
+
+	if !hashable(value) {
+		return nil, false
+	}
+
+	return fsys, true
+}
+
+// hashable reports whether value can be used as a map key without panicking.
+// Interfaces are checked by the value they hold, rather than their type.
+func hashable(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return false
+	case reflect.Interface:
+		return value.IsNil() || hashable(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !hashable(value.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if !hashable(value.Index(i)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/sironfoot/transfig
+
+go 1.20
@@ -0,0 +1,251 @@
+package transfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HTTPSource is a Source that fetches a JSON config document from a URL. Requests
+// after the first are conditional (If-None-Match/If-Modified-Since), so polling for
+// changes only downloads the document again when the server says it has changed.
+//
+// If the server can't be reached, the last good copy of the document is used
+// instead, either from memory or from CacheFile.
+type HTTPSource struct {
+	// URL of the JSON config document
+	URL string
+
+	// Client is used to make requests. If nil, a client is created that trusts
+	// the certificate authorities in CAFile as well as the system roots.
+	Client *http.Client
+
+	// CAFile is the path to a PEM bundle of extra certificate authorities to
+	// trust. It is ignored if Client is set.
+	CAFile string
+
+	// BearerToken, if set, is sent in the Authorization header
+	BearerToken string
+
+	// Username and Password, if set, are sent using basic authentication
+	Username string
+	Password string
+
+	// Header holds extra headers to send with each request
+	Header http.Header
+
+	// CacheFile, if set, is where the last good copy of the document is saved,
+	// so it can be used if the server is unreachable when the program starts.
+	CacheFile string
+
+	mu           sync.Mutex
+	client       *http.Client
+	data         []byte
+	version      string
+	etag         string
+	lastModified string
+}
+
+// Read fetches the JSON document, returning the last good copy if the server can't be reached.
+// If the server responds with 404 Not Found, the error satisfies errors.Is(err, fs.ErrNotExist).
+func (h *HTTPSource) Read() ([]byte, error) {
+	return h.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but the request is cancelled once ctx is done, in which case
+// the last good copy isn't used and the context's error is returned.
+func (h *HTTPSource) ReadContext(ctx context.Context) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.data, nil
+}
+
+// Version fetches the JSON document if it has changed, and returns a hash of its contents.
+func (h *HTTPSource) Version() (string, error) {
+	return h.VersionContext(context.Background())
+}
+
+// VersionContext is like Version, but the request is cancelled once ctx is done.
+func (h *HTTPSource) VersionContext(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return h.version, nil
+}
+
+func (h *HTTPSource) fetch(ctx context.Context) error {
+	client, err := h.httpClient()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return fmt.Errorf("config: invalid config URL \"%s\": %s", h.URL, err)
+	}
+
+	for name, values := range h.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	} else if h.Username != "" || h.Password != "" {
+		req.SetBasicAuth(h.Username, h.Password)
+	}
+
+	if h.data != nil {
+		if h.etag != "" {
+			req.Header.Set("If-None-Match", h.etag)
+		}
+		if h.lastModified != "" {
+			req.Header.Set("If-Modified-Since", h.lastModified)
+		}
+	}
+
+	res, err := client.Do(req)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return h.fallback(err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && h.data != nil:
+		return nil
+	case res.StatusCode == http.StatusNotFound:
+		return &fs.PathError{Op: "get", Path: h.URL, Err: fs.ErrNotExist}
+	case res.StatusCode >= 500:
+		return h.fallback(fmt.Errorf("GET %s: %s", h.URL, res.Status))
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("GET %s: %s", h.URL, res.Status)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return h.fallback(err)
+	}
+
+	h.setData(data)
+	h.etag = res.Header.Get("ETag")
+	h.lastModified = res.Header.Get("Last-Modified")
+
+	if h.CacheFile != "" {
+		err = writeFileAtomic(h.CacheFile, data)
+		if err != nil {
+			return fmt.Errorf("config: error saving cache file \"%s\": %s", h.CacheFile, err)
+		}
+	}
+
+	return nil
+}
+
+// fallback is called when the server is unreachable. The copy of the document held
+// in memory is kept if there is one, otherwise the CacheFile is read.
+func (h *HTTPSource) fallback(fetchErr error) error {
+	if h.data != nil {
+		return nil
+	}
+
+	if h.CacheFile == "" {
+		return fetchErr
+	}
+
+	data, err := os.ReadFile(h.CacheFile)
+	if err != nil {
+		return fmt.Errorf("%s (and cache file unavailable: %s)", fetchErr, err)
+	}
+
+	h.setData(data)
+
+	return nil
+}
+
+func (h *HTTPSource) setData(data []byte) {
+	sum := sha256.Sum256(data)
+	h.data = data
+	h.version = hex.EncodeToString(sum[:])
+}
+
+func (h *HTTPSource) httpClient() (*http.Client, error) {
+	if h.Client != nil {
+		return h.Client, nil
+	}
+
+	if h.client != nil {
+		return h.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("config: error opening CA file: %s", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("config: no certificates found in CA file \"%s\"", h.CAFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	h.client = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(time.Second * 30),
+	}
+
+	return h.client, nil
+}
+
+// writeFileAtomic writes data to a temporary file alongside path, then renames it
+// over path, so a crash never leaves a half written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,227 @@
+package transfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// includeDirective is the key of an object whose value names another file (or a glob
+// matching several files) to splice into that object, e.g.
+//
+//	"logging": { "$include": "shared/logging.json", "level": "debug" }
+//
+// Paths are relative to the including file. Included files are merged in order, then any
+// other keys in the object are applied over the top. The value may also be an array of paths.
+const includeDirective = "$include"
+
+// hasIncludes reports whether data might contain an include directive, so
+// files without one can skip the extra decode/encode.
+func hasIncludes(data []byte) bool {
+	return bytes.Contains(data, []byte(`"`+includeDirective+`"`))
+}
+
+// resolveIncludes splices every included file into data, which was read from the file
+// name in fsys. It returns the resulting JSON document, along with the include patterns
+// (relative to fsys) it depends on, so changes to them can be detected.
+func resolveIncludes(fsys fs.FS, name string, data []byte) ([]byte, []string, error) {
+	resolver := includeResolver{
+		fs:    fsys,
+		stack: []string{name},
+	}
+
+	document, err := resolver.decode(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved, err := json.Marshal(document)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resolved, resolver.patterns, nil
+}
+
+type includeResolver struct {
+	fs       fs.FS
+	stack    []string
+	patterns []string
+}
+
+func (r *includeResolver) decode(name string, data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(stripComments(data)))
+	decoder.UseNumber()
+
+	var document interface{}
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal \"%s\": %s", name, err)
+	}
+
+	return r.resolve(path.Dir(name), document)
+}
+
+func (r *includeResolver) resolve(dir string, value interface{}) (interface{}, error) {
+	switch realValue := value.(type) {
+	case map[string]interface{}:
+		include, hasInclude := realValue[includeDirective]
+		delete(realValue, includeDirective)
+
+		for key, child := range realValue {
+			resolved, err := r.resolve(dir, child)
+			if err != nil {
+				return nil, err
+			}
+			realValue[key] = resolved
+		}
+
+		if !hasInclude {
+			return realValue, nil
+		}
+
+		included, err := r.include(dir, include)
+		if err != nil {
+			return nil, err
+		}
+
+		includedMap, ok := included.(map[string]interface{})
+		if !ok {
+			if len(realValue) > 0 {
+				return nil, fmt.Errorf("cannot merge keys into an included value that isn't an object")
+			}
+			return included, nil
+		}
+
+		mergeMaps(includedMap, realValue)
+		return includedMap, nil
+	case []interface{}:
+		for i, item := range realValue {
+			resolved, err := r.resolve(dir, item)
+			if err != nil {
+				return nil, err
+			}
+			realValue[i] = resolved
+		}
+	}
+
+	return value, nil
+}
+
+// include reads and merges every file matched by the include directive's value
+func (r *includeResolver) include(dir string, include interface{}) (interface{}, error) {
+	var patterns []string
+
+	switch realInclude := include.(type) {
+	case string:
+		patterns = []string{realInclude}
+	case []interface{}:
+		for _, item := range realInclude {
+			pattern, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a path or an array of paths", includeDirective)
+			}
+			patterns = append(patterns, pattern)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a path or an array of paths", includeDirective)
+	}
+
+	var result interface{}
+	for _, pattern := range patterns {
+		pattern = path.Join(dir, pattern)
+		r.patterns = append(r.patterns, pattern)
+
+		names, err := fs.Glob(r.fs, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s \"%s\": %s", includeDirective, pattern, err)
+		}
+
+		// a pattern without wildcards must match an actual file
+		if len(names) == 0 && !isGlob(pattern) {
+			return nil, fmt.Errorf("included file \"%s\": %w", pattern, fs.ErrNotExist)
+		}
+
+		for _, name := range names {
+			included, err := r.includeFile(name)
+			if err != nil {
+				return nil, err
+			}
+
+			resultMap, resultIsMap := result.(map[string]interface{})
+			includedMap, includedIsMap := included.(map[string]interface{})
+			if resultIsMap && includedIsMap {
+				mergeMaps(resultMap, includedMap)
+			} else {
+				result = included
+			}
+		}
+	}
+
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+
+	return result, nil
+}
+
+func (r *includeResolver) includeFile(name string) (interface{}, error) {
+	for _, including := range r.stack {
+		if including == name {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(r.stack, " -> "), name)
+		}
+	}
+
+	data, err := fs.ReadFile(r.fs, name)
+	if err != nil {
+		return nil, fmt.Errorf("included file: %w", err)
+	}
+
+	r.stack = append(r.stack, name)
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+
+	return r.decode(name, data)
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// includesVersion returns a value that changes whenever a file matching one of the
+// include patterns is added, removed or modified, along with the latest modification
+// time of those files.
+func includesVersion(fsys fs.FS, patterns []string) (string, time.Time) {
+	var latest time.Time
+	if len(patterns) == 0 {
+		return "", latest
+	}
+
+	hash := sha256.New()
+	for _, pattern := range patterns {
+		names, _ := fs.Glob(fsys, pattern)
+		fmt.Fprintf(hash, "%s\x00%d\x00", pattern, len(names))
+
+		for _, name := range names {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				fmt.Fprintf(hash, "%s\x00missing\x00", name)
+				continue
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", name, info.Size(), info.ModTime().UnixNano())
+
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), latest
+}
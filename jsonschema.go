@@ -0,0 +1,556 @@
+package transfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONSchema is a JSON Schema that config documents are checked against before they're
+// decoded, when it's set as a Loader's Schema. It supports the parts of draft 2020-12
+// that describe the shape of a document: type, required, enum, const, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, minItems, maxItems,
+// properties, additionalProperties, items, and $ref to the schema's own $defs. Other
+// keywords are ignored.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+// ParseSchema parses a JSON Schema document, such as one written by Schema.
+func ParseSchema(data []byte) (*JSONSchema, error) {
+	var root schemaNode
+	err := json.Unmarshal(data, &root)
+	if err != nil {
+		return nil, fmt.Errorf("config: cannot parse schema: %s", err)
+	}
+
+	err = root.resolve(&root, "#")
+	if err != nil {
+		return nil, fmt.Errorf("config: cannot parse schema: %s", err)
+	}
+
+	return &JSONSchema{root: &root}, nil
+}
+
+// SchemaError is returned when a config document doesn't match a Loader's Schema. Like
+// ValidationError, it lists every problem with the document, not just the first.
+type SchemaError struct {
+	// Document is the document that didn't match, e.g. "primary config",
+	// "environment config", or "merged config"
+	Document string
+
+	// Violations are the problems with the document. Each Rule is the
+	// schema keyword that failed, e.g. "required" or "maximum".
+	Violations []Violation
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("config: %s doesn't match schema: %s", e.Document, formatViolations(e.Violations))
+}
+
+// check checks the primary and environment config documents against the schema, then the
+// document made by merging them, the way the environment configs are applied to the config.
+// Each document on its own only needs to be part of a config, so required is only checked
+// once they're merged. Documents that aren't valid JSON are left for decoding to report.
+//
+// Environment configs, including sources such as a KVSource that only hold strings, may
+// write any value as a string, which is converted to the type of the field it's applied to.
+// Their strings are converted to the type the schema expects before they're checked.
+func (s *JSONSchema) check(data []byte, envData [][]byte) error {
+	merged, err := decodeDocument(data)
+	if err != nil {
+		return nil
+	}
+
+	err = s.checkDocument("primary config", merged, true)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range envData {
+		document, err := decodeDocument(layer)
+		if err != nil {
+			return nil
+		}
+		document = s.root.coerce(document)
+
+		name := "environment config"
+		if len(envData) > 1 {
+			name = fmt.Sprintf("environment config %d", i+1)
+		}
+
+		err = s.checkDocument(name, document, true)
+		if err != nil {
+			return err
+		}
+
+		merged = mergeDocuments(merged, document)
+	}
+
+	return s.checkDocument("merged config", merged, false)
+}
+
+func (s *JSONSchema) checkDocument(name string, document interface{}, partial bool) error {
+	c := schemaChecker{partial: partial}
+	c.check("", document, s.root)
+
+	if len(c.violations) > 0 {
+		return &SchemaError{Document: name, Violations: c.violations}
+	}
+
+	return nil
+}
+
+func decodeDocument(data []byte) (interface{}, error) {
+	var document interface{}
+	err := json.Unmarshal(stripComments(data), &document)
+	return document, err
+}
+
+// mergeDocuments applies overlay over the top of base. Objects are merged key by key,
+// nulls are ignored, and anything else replaces what was there.
+func mergeDocuments(base, overlay interface{}) interface{} {
+	if overlay == nil {
+		return base
+	}
+
+	baseMap, baseIsMap := base.(map[string]interface{})
+	overlayMap, overlayIsMap := overlay.(map[string]interface{})
+	if !baseIsMap || !overlayIsMap {
+		return overlay
+	}
+
+	merged := make(map[string]interface{}, len(baseMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overlayMap {
+		merged[key] = mergeDocuments(merged[key], value)
+	}
+
+	return merged
+}
+
+// schemaNode is a schema, or a subschema within one
+type schemaNode struct {
+	// never is set for the schema false, which nothing matches
+	never bool
+
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*schemaNode `json:"$defs"`
+	Definitions          map[string]*schemaNode `json:"definitions"`
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                *interface{}           `json:"const"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *schemaNode            `json:"additionalProperties"`
+	Items                *schemaNode            `json:"items"`
+
+	ref     *schemaNode
+	pattern *regexp.Regexp
+}
+
+func (n *schemaNode) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*n = schemaNode{}
+		return nil
+	case "false":
+		*n = schemaNode{never: true}
+		return nil
+	}
+
+	// an alias type doesn't have this UnmarshalJSON method, so it can be decoded as usual
+	type plainNode schemaNode
+	return json.Unmarshal(data, (*plainNode)(n))
+}
+
+// resolve compiles the node's pattern and finds the schema its $ref refers
+// to, and those of its subschemas. location is used in error messages.
+func (n *schemaNode) resolve(root *schemaNode, location string) error {
+	if n == nil {
+		return nil
+	}
+
+	if n.Pattern != "" {
+		pattern, err := compileRegexp(n.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %s", location, err)
+		}
+		n.pattern = pattern
+	}
+
+	if n.Ref != "" {
+		n.ref = root.lookup(n.Ref)
+		if n.ref == nil {
+			return fmt.Errorf("%s: can't find $ref \"%s\"", location, n.Ref)
+		}
+
+		// a $ref that leads back to itself, without going through properties
+		// or items, would be checked against the same value forever
+		seen := map[*schemaNode]bool{n: true}
+		for next := n.ref; next != nil && next.Ref != ""; next = root.lookup(next.Ref) {
+			if seen[next] {
+				return fmt.Errorf("%s: $ref \"%s\" refers back to itself", location, n.Ref)
+			}
+			seen[next] = true
+		}
+	}
+
+	for name, def := range n.Defs {
+		err := def.resolve(root, location+"/$defs/"+name)
+		if err != nil {
+			return err
+		}
+	}
+	for name, def := range n.Definitions {
+		err := def.resolve(root, location+"/definitions/"+name)
+		if err != nil {
+			return err
+		}
+	}
+	for name, property := range n.Properties {
+		err := property.resolve(root, location+"/properties/"+name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := n.AdditionalProperties.resolve(root, location+"/additionalProperties")
+	if err != nil {
+		return err
+	}
+
+	return n.Items.resolve(root, location+"/items")
+}
+
+// lookup finds the schema a $ref refers to. Only references within the
+// schema itself are supported, e.g. "#" or "#/$defs/server".
+func (n *schemaNode) lookup(ref string) *schemaNode {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+
+	node := n
+	segments := strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:]
+
+	for i := 0; i < len(segments) && node != nil; i++ {
+		segment := strings.ReplaceAll(strings.ReplaceAll(segments[i], "~1", "/"), "~0", "~")
+
+		switch {
+		case segment == "items":
+			node = node.Items
+		case segment == "additionalProperties":
+			node = node.AdditionalProperties
+		case i+1 < len(segments):
+			name := strings.ReplaceAll(strings.ReplaceAll(segments[i+1], "~1", "/"), "~0", "~")
+			switch segment {
+			case "$defs":
+				node = node.Defs[name]
+			case "definitions":
+				node = node.Definitions[name]
+			case "properties":
+				node = node.Properties[name]
+			default:
+				return nil
+			}
+			i++
+		default:
+			return nil
+		}
+	}
+
+	return node
+}
+
+// coerce converts the strings within value to the type n expects, where they can be
+// converted, the way parseString converts strings to the type of the field they're applied
+// to. Numbers may also be written as durations, e.g. "30s", which are converted to nanoseconds.
+func (n *schemaNode) coerce(value interface{}) interface{} {
+	if n == nil || n.never {
+		return value
+	}
+
+	if n.ref != nil {
+		value = n.ref.coerce(value)
+	}
+
+	switch realValue := value.(type) {
+	case string:
+		if len(n.Type) == 0 || n.Type.includes("string") {
+			return value
+		}
+
+		for _, target := range n.Type {
+			if converted, ok := convertString(realValue, target); ok {
+				return n.coerce(converted)
+			}
+		}
+	case map[string]interface{}:
+		coerced := make(map[string]interface{}, len(realValue))
+		for key, property := range realValue {
+			if node, exists := n.Properties[key]; exists {
+				coerced[key] = node.coerce(property)
+			} else {
+				coerced[key] = n.AdditionalProperties.coerce(property)
+			}
+		}
+		return coerced
+	case []interface{}:
+		coerced := make([]interface{}, len(realValue))
+		for i, item := range realValue {
+			coerced[i] = n.Items.coerce(item)
+		}
+		return coerced
+	}
+
+	return value
+}
+
+// convertString converts a string to a value of the JSON type target
+func convertString(value, target string) (interface{}, bool) {
+	switch target {
+	case "integer", "number":
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number, true
+		}
+		if duration, err := time.ParseDuration(value); err == nil {
+			return float64(duration), true
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, true
+		}
+	case "object", "array":
+		var document interface{}
+		if json.Unmarshal([]byte(value), &document) == nil && target == jsonType(document) {
+			return document, true
+		}
+	}
+
+	return nil, false
+}
+
+// schemaTypes is the value of a type keyword, which may be a single type or a list of them
+type schemaTypes []string
+
+func (t schemaTypes) includes(name string) bool {
+	for _, included := range t {
+		if included == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+type schemaChecker struct {
+	// partial is set when checking a document that is only part of a config,
+	// such as an environment config, so required properties may be missing
+	partial    bool
+	violations []Violation
+}
+
+func (c *schemaChecker) violation(path, keyword, message string, args ...interface{}) {
+	c.violations = append(c.violations, Violation{
+		Path:    path,
+		Rule:    keyword,
+		Message: fmt.Sprintf(message, args...),
+	})
+}
+
+func (c *schemaChecker) check(path string, value interface{}, node *schemaNode) {
+	if node.never {
+		c.violation(path, "false", "is not allowed")
+		return
+	}
+
+	if node.ref != nil {
+		c.check(path, value, node.ref)
+	}
+
+	if len(node.Type) > 0 && !matchesType(value, node.Type) {
+		c.violation(path, "type", "must be of type %s, not %s", strings.Join(node.Type, " or "), jsonType(value))
+
+		// the other keywords don't mean much for a value of the wrong type
+		return
+	}
+
+	if node.Enum != nil && !containsValue(node.Enum, value) {
+		c.violation(path, "enum", "must be one of %s, not %s", formatValues(node.Enum), formatValue(value))
+	}
+
+	if node.Const != nil && !reflect.DeepEqual(*node.Const, value) {
+		c.violation(path, "const", "must be %s, not %s", formatValue(*node.Const), formatValue(value))
+	}
+
+	switch realValue := value.(type) {
+	case string:
+		c.checkString(path, realValue, node)
+	case float64:
+		c.checkNumber(path, realValue, node)
+	case []interface{}:
+		c.checkArray(path, realValue, node)
+	case map[string]interface{}:
+		c.checkObject(path, realValue, node)
+	}
+}
+
+func (c *schemaChecker) checkString(path string, value string, node *schemaNode) {
+	length := len([]rune(value))
+
+	if node.MinLength != nil && length < *node.MinLength {
+		c.violation(path, "minLength", "must have a length of at least %d", *node.MinLength)
+	}
+
+	if node.MaxLength != nil && length > *node.MaxLength {
+		c.violation(path, "maxLength", "must have a length of at most %d", *node.MaxLength)
+	}
+
+	if node.pattern != nil && !node.pattern.MatchString(value) {
+		c.violation(path, "pattern", "must match %s", node.Pattern)
+	}
+}
+
+func (c *schemaChecker) checkNumber(path string, value float64, node *schemaNode) {
+	if node.Minimum != nil && value < *node.Minimum {
+		c.violation(path, "minimum", "must be at least %v", *node.Minimum)
+	}
+
+	if node.Maximum != nil && value > *node.Maximum {
+		c.violation(path, "maximum", "must be at most %v", *node.Maximum)
+	}
+
+	if node.ExclusiveMinimum != nil && value <= *node.ExclusiveMinimum {
+		c.violation(path, "exclusiveMinimum", "must be greater than %v", *node.ExclusiveMinimum)
+	}
+
+	if node.ExclusiveMaximum != nil && value >= *node.ExclusiveMaximum {
+		c.violation(path, "exclusiveMaximum", "must be less than %v", *node.ExclusiveMaximum)
+	}
+}
+
+func (c *schemaChecker) checkArray(path string, value []interface{}, node *schemaNode) {
+	if node.MinItems != nil && len(value) < *node.MinItems {
+		c.violation(path, "minItems", "must have at least %d items", *node.MinItems)
+	}
+
+	if node.MaxItems != nil && len(value) > *node.MaxItems {
+		c.violation(path, "maxItems", "must have at most %d items", *node.MaxItems)
+	}
+
+	if node.Items != nil {
+		for i, item := range value {
+			c.check(fmt.Sprintf("%s[%d]", path, i), item, node.Items)
+		}
+	}
+}
+
+func (c *schemaChecker) checkObject(path string, value map[string]interface{}, node *schemaNode) {
+	if !c.partial {
+		for _, name := range node.Required {
+			if _, exists := value[name]; !exists {
+				c.violation(joinPath(path, name), "required", "is required")
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if property, exists := node.Properties[key]; exists {
+			c.check(joinPath(path, key), value[key], property)
+		} else if node.AdditionalProperties != nil {
+			if node.AdditionalProperties.never {
+				c.violation(joinPath(path, key), "additionalProperties", "is not allowed")
+			} else {
+				c.check(joinPath(path, key), value[key], node.AdditionalProperties)
+			}
+		}
+	}
+}
+
+func matchesType(value interface{}, types schemaTypes) bool {
+	actual := jsonType(value)
+
+	for _, expected := range types {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value. Whole
+// numbers are integers, even when they're written with a decimal point.
+func jsonType(value interface{}) string {
+	switch realValue := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if realValue == math.Trunc(realValue) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, allowed := range values {
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = formatValue(value)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func formatValue(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
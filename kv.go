@@ -0,0 +1,204 @@
+package transfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KVPair is a single key and value read from a key/value store
+type KVPair struct {
+	Key   string
+	Value []byte
+}
+
+// KVStore is a key/value store, such as Consul or etcd, holding config
+// settings as flattened keys, e.g. "myapp/database/connectionString".
+type KVStore interface {
+	// List returns every pair whose key starts with prefix, along with the
+	// store's index (or revision) at the time they were read.
+	List(prefix string) (pairs []KVPair, index uint64, err error)
+}
+
+// KVContextStore is a KVStore whose keys can be listed using a context, so a
+// KVSource reading it can be cancelled.
+type KVContextStore interface {
+	KVStore
+
+	// ListContext is like List, but gives up once ctx is done.
+	ListContext(ctx context.Context, prefix string) (pairs []KVPair, index uint64, err error)
+}
+
+// KVWatcher is a KVStore that supports blocking queries or watches.
+type KVWatcher interface {
+	KVStore
+
+	// Wait blocks until a key under prefix changes after index, returning the new index.
+	// It may also return early (e.g. on a server side timeout) with an unchanged index,
+	// and must return promptly once stop is closed.
+	Wait(prefix string, index uint64, stop <-chan struct{}) (uint64, error)
+}
+
+// KVSource returns a Source that reads every key under prefix from store, and folds them
+// into a JSON document by splitting the rest of each key on "/". For example, with a prefix
+// of "myapp/" the key "myapp/database/connectionString" sets the connectionString field of
+// the database object.
+//
+// Values are strings, and are converted to the type of the field they are applied to, so
+// KVSource is best used as an override layer on top of a primary config file. If store is
+// a KVWatcher, changes are picked up using blocking queries rather than polling.
+func KVSource(store KVStore, prefix string) Source {
+	return &kvSource{
+		store:  store,
+		prefix: prefix,
+	}
+}
+
+type kvSource struct {
+	store  KVStore
+	prefix string
+
+	mu    sync.Mutex
+	index uint64
+}
+
+func (k *kvSource) list(ctx context.Context) ([]KVPair, error) {
+	var pairs []KVPair
+	var index uint64
+	var err error
+
+	if contextStore, ok := k.store.(KVContextStore); ok {
+		pairs, index, err = contextStore.ListContext(ctx, k.prefix)
+	} else {
+		pairs, index, err = k.store.List(k.prefix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: error listing keys under \"%s\": %w", k.prefix, err)
+	}
+
+	k.mu.Lock()
+	k.index = index
+	k.mu.Unlock()
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	return pairs, nil
+}
+
+func (k *kvSource) Read() ([]byte, error) {
+	return k.ReadContext(context.Background())
+}
+
+// ReadContext lists the keys using ctx, if the store is a KVContextStore.
+func (k *kvSource) ReadContext(ctx context.Context) ([]byte, error) {
+	pairs, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := map[string]interface{}{}
+	for _, pair := range pairs {
+		key := strings.Trim(strings.TrimPrefix(pair.Key, k.prefix), "/")
+		if key == "" {
+			continue
+		}
+
+		setPath(tree, strings.Split(key, "/"), string(pair.Value))
+	}
+
+	return json.Marshal(tree)
+}
+
+// Version is a hash of every key and value, so unrelated writes elsewhere
+// in the store don't cause a reload.
+func (k *kvSource) Version() (string, error) {
+	return k.VersionContext(context.Background())
+}
+
+// VersionContext lists the keys using ctx, if the store is a KVContextStore.
+func (k *kvSource) VersionContext(ctx context.Context) (string, error) {
+	pairs, err := k.list(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, pair := range pairs {
+		fmt.Fprintf(hash, "%d:%s%d:", len(pair.Key), pair.Key, len(pair.Value))
+		hash.Write(pair.Value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Watch waits for changes made after the index the keys were last read at,
+// so version isn't needed.
+func (k *kvSource) Watch(version string, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	watcher, ok := k.store.(KVWatcher)
+	if !ok {
+		return nil, fmt.Errorf("config: key/value store does not support watching")
+	}
+
+	k.mu.Lock()
+	index := k.index
+	k.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for {
+			newIndex, err := watcher.Wait(k.prefix, index, stop)
+
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if err != nil {
+				// back off, so an unreachable store isn't hammered with requests
+				select {
+				case <-time.After(time.Duration(time.Second * 5)):
+				case <-stop:
+					return
+				}
+				continue
+			}
+
+			if newIndex != index {
+				index = newIndex
+				changed()
+			}
+		}
+	}()
+
+	return done, nil
+}
+
+// setPath sets value in tree at the path of keys, creating nested maps as needed.
+// A value already at a parent path is replaced by a map.
+func setPath(tree map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		if key == "" {
+			continue
+		}
+
+		child, ok := tree[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			tree[key] = child
+		}
+		tree = child
+	}
+
+	tree[path[len(path)-1]] = value
+}
@@ -0,0 +1,624 @@
+package transfig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultReloadPollingInterval is how often a Loader checks its cached
+// configs for changes, unless SetReloadPollingInterval is called.
+const DefaultReloadPollingInterval = time.Duration(time.Second * 5)
+
+// Loader loads configs and caches them, with its own cache, reload polling interval and
+// sources, so that different parts of a program can load config independently of each
+// other. The zero value is ready to use. The package level functions, such as Load and
+// LoadWithCaching, use a default Loader.
+//
+// A Loader doesn't start polling for changes until a config is first cached, and carries on
+// until Close is called.
+type Loader struct {
+	// Sources are applied over the top of every config the Loader loads, after the
+	// environment config file, e.g. a KVSource holding overrides. Sources must be
+	// set before the Loader is first used.
+	Sources []Source
+
+	// Schema, if set, is a JSON Schema that config documents must match before they're
+	// decoded. The primary config and each environment config are checked on their own,
+	// ignoring required, then the result of merging them is checked in full. Strings in
+	// environment configs and override sources are converted to the type the schema
+	// expects first, as they are when applied. Schema must be set before the Loader is
+	// first used.
+	Schema *JSONSchema
+
+	cacheMux sync.RWMutex
+	cache    map[interface{}]*cachedConfig
+
+	// loading holds the loads in progress, so a slow source only holds up callers waiting
+	// for the same key. closes counts calls to Close, so a load that was in progress when
+	// the Loader was closed isn't cached.
+	loading map[interface{}]*pendingLoad
+	closes  int
+
+	pollingMux      sync.Mutex
+	pollingInterval time.Duration
+	pollingTicker   *time.Ticker
+	stopPolling     chan bool
+
+	subscribersMux sync.RWMutex
+	subscribers    map[*subscriber]struct{}
+
+	// background tracks the polling goroutine and any reloads in progress, so Close can
+	// wait for them. reloadContext is cancelled by Close to interrupt those reloads.
+	background    sync.WaitGroup
+	reloadContext context.Context
+	cancelReloads context.CancelFunc
+}
+
+// pendingLoad is a load in progress, which other callers for the same key wait for
+type pendingLoad struct {
+	done       chan struct{}
+	configData interface{}
+	err        error
+}
+
+type cachedConfig struct {
+	Sources      []Source
+	Versions     []string
+	Watched      []bool
+	ConfigData   interface{}
+	load         func(context.Context, []Source) (interface{}, error)
+	stored       func(configData interface{})
+	reloading    bool
+	stopWatching chan struct{}
+}
+
+// ReloadPollingInterval returns how often the Loader checks its cached configs for changes.
+func (l *Loader) ReloadPollingInterval() time.Duration {
+	l.pollingMux.Lock()
+	defer l.pollingMux.Unlock()
+
+	if l.pollingInterval == 0 {
+		return DefaultReloadPollingInterval
+	}
+	return l.pollingInterval
+}
+
+// SetReloadPollingInterval determines how often we should
+// check for changes to the config files, so we can reload them.
+func (l *Loader) SetReloadPollingInterval(duration time.Duration) {
+	l.pollingMux.Lock()
+	defer l.pollingMux.Unlock()
+
+	l.pollingInterval = duration
+
+	if l.pollingTicker != nil {
+		l.stopPolling <- true
+		l.pollingTicker.Stop()
+		l.pollingTicker = nil
+		l.startPolling()
+	}
+}
+
+// ensurePolling starts polling for changes, if it hasn't started already.
+func (l *Loader) ensurePolling() {
+	l.pollingMux.Lock()
+	defer l.pollingMux.Unlock()
+
+	if l.pollingTicker == nil {
+		l.startPolling()
+	}
+}
+
+// startPolling must be called with pollingMux held
+func (l *Loader) startPolling() {
+	interval := l.pollingInterval
+	if interval == 0 {
+		interval = DefaultReloadPollingInterval
+	}
+
+	if l.stopPolling == nil {
+		l.stopPolling = make(chan bool)
+	}
+
+	l.pollingTicker = time.NewTicker(interval)
+	l.background.Add(1)
+
+	go func(ticker *time.Ticker, stop chan bool) {
+		defer l.background.Done()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.poll()
+			case <-stop:
+				return
+			}
+		}
+	}(l.pollingTicker, l.stopPolling)
+}
+
+// poll checks every cached config for changes, and reloads the ones that have changed.
+// Each config is checked independently, so one that can't be read doesn't stop the
+// others being reloaded.
+func (l *Loader) poll() {
+	l.cacheMux.RLock()
+	ctx := l.reloadContext
+	keys := make([]interface{}, 0, len(l.cache))
+	configs := make([]*cachedConfig, 0, len(l.cache))
+	watched := make([][]bool, 0, len(l.cache))
+	for key, config := range l.cache {
+		keys = append(keys, key)
+		configs = append(configs, config)
+		watched = append(watched, append([]bool(nil), config.Watched...))
+	}
+	l.cacheMux.RUnlock()
+
+	for i, config := range configs {
+		if config.changed(ctx, watched[i]) {
+			l.reload(keys[i], config)
+		}
+	}
+}
+
+// changed reports whether the Version of any of the config's polled sources, i.e. those
+// that aren't watched, has changed. A source whose Version can't be read, e.g. because it
+// was deleted or its permissions changed, counts as changed, so that reloading it reports
+// the error.
+func (c *cachedConfig) changed(ctx context.Context, watched []bool) bool {
+	for i, source := range c.Sources {
+		if watched[i] {
+			continue
+		}
+
+		// don't mistake being closed for a change
+		version := sourceVersion(ctx, source)
+		if ctx.Err() != nil {
+			return false
+		}
+
+		if version != c.Versions[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withSources returns sources followed by the Loader's own Sources
+func (l *Loader) withSources(sources []Source) []Source {
+	if len(l.Sources) == 0 {
+		return sources
+	}
+
+	return append(append([]Source{}, sources...), l.Sources...)
+}
+
+// Load will load a configuration json file into a struct. See the package level Load.
+func (l *Loader) Load(path, environment string, configData interface{}) error {
+	return l.LoadFS(osFS{}, path, environment, configData)
+}
+
+// LoadContext is like Load, but stops loading once ctx is done. See the package level LoadContext.
+func (l *Loader) LoadContext(ctx context.Context, path, environment string, configData interface{}) error {
+	return l.LoadSourcesContext(ctx, configData, configSources(osFS{}, path, environment)...)
+}
+
+// LoadFS will load a configuration json file from fsys into a struct. See the package level LoadFS.
+func (l *Loader) LoadFS(fsys fs.FS, path, environment string, configData interface{}) error {
+	return l.LoadSources(configData, configSources(fsys, path, environment)...)
+}
+
+// LoadReader will load a primary and environment configuration json document into a struct.
+// See the package level LoadReader.
+func (l *Loader) LoadReader(primary, environment io.Reader, configData interface{}) error {
+	data, envData, err := readDocuments(primary, environment)
+	if err != nil {
+		return err
+	}
+
+	return l.LoadBytes(data, envData, configData)
+}
+
+// LoadBytes will load a primary and environment configuration json document into a struct.
+// See the package level LoadBytes.
+func (l *Loader) LoadBytes(data, envData []byte, configData interface{}) error {
+	sources := []Source{BytesSource(data)}
+	if envData != nil {
+		sources = append(sources, BytesSource(envData))
+	}
+
+	return l.LoadSources(configData, sources...)
+}
+
+// LoadSources will load an ordered list of sources into a struct. See the package level LoadSources.
+func (l *Loader) LoadSources(configData interface{}, sources ...Source) error {
+	return l.LoadSourcesContext(context.Background(), configData, sources...)
+}
+
+// LoadSourcesContext is like LoadSources, but stops reading the sources once ctx is done.
+// See the package level LoadSourcesContext.
+func (l *Loader) LoadSourcesContext(ctx context.Context, configData interface{}, sources ...Source) error {
+	if reflect.TypeOf(configData).Kind() != reflect.Ptr {
+		return ErrConfigDataNotPointer
+	}
+
+	return loadSources(ctx, configData, l.withSources(sources), l.Schema)
+}
+
+// LoadWithCaching will load a configuration json file into a struct with built in support for caching
+func (l *Loader) LoadWithCaching(path, environment string, configData interface{}) error {
+	return l.LoadWithCachingFS(osFS{}, path, environment, configData)
+}
+
+// LoadWithCachingContext is like LoadWithCaching, but if the config isn't cached,
+// stops loading it once ctx is done.
+func (l *Loader) LoadWithCachingContext(ctx context.Context, path, environment string, configData interface{}) error {
+	key, err := newFileKey(osFS{}, path, environment)
+	if err != nil {
+		return err
+	}
+
+	return l.loadWithCaching(ctx, key, configData, func() []Source {
+		return configSources(osFS{}, path, environment)
+	})
+}
+
+// LoadWithCachingFS is like LoadWithCaching, but reads the primary and environment config
+// files from fsys. Changes are only picked up if fsys reports modification times. It returns
+// ErrFSNotCacheable if fsys can't be used to identify the cached config.
+func (l *Loader) LoadWithCachingFS(fsys fs.FS, path, environment string, configData interface{}) error {
+	key, err := newFileKey(fsys, path, environment)
+	if err != nil {
+		return err
+	}
+
+	return l.loadWithCaching(context.Background(), key, configData, func() []Source {
+		return configSources(fsys, path, environment)
+	})
+}
+
+// LoadSourcesWithCaching is like LoadSources, but caches the result under key. See the
+// package level LoadSourcesWithCaching.
+func (l *Loader) LoadSourcesWithCaching(key string, configData interface{}, sources ...Source) error {
+	return l.loadWithCaching(context.Background(), key, configData, func() []Source {
+		return sources
+	})
+}
+
+func (l *Loader) loadWithCaching(ctx context.Context, key interface{}, configData interface{}, sources func() []Source) error {
+	if reflect.TypeOf(configData).Kind() != reflect.Ptr {
+		return ErrConfigDataNotPointer
+	}
+
+	configType := reflect.TypeOf(configData).Elem()
+
+	value, err := l.cached(ctx, key, sources, false, func(ctx context.Context, sources []Source) (interface{}, error) {
+		newConfigData := reflect.New(configType)
+		err := loadSources(ctx, newConfigData.Interface(), sources, l.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return newConfigData.Elem().Interface(), nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	reflect.ValueOf(configData).Elem().Set(reflect.ValueOf(copyConfig(value)))
+
+	return nil
+}
+
+// cached returns the config cached under key, calling load to load it from the sources
+// returned by sources if it isn't cached, or if reload is true. sources is only called
+// when loading, so a cached config is returned without building them. Background reloads
+// call load with a context that is cancelled when the Loader is closed. stored, if it isn't
+// nil, is called with the cache lock held whenever a newly loaded config replaces the
+// cached one, and with nil when it's evicted because its primary config no longer exists,
+// or the Loader is closed.
+func (l *Loader) cached(ctx context.Context, key interface{}, sources func() []Source, reload bool, load func(context.Context, []Source) (interface{}, error), stored func(configData interface{})) (interface{}, error) {
+	if !reload {
+		l.cacheMux.RLock()
+		config, isCached := l.cache[key]
+		l.cacheMux.RUnlock()
+
+		if isCached {
+			return config.ConfigData, nil
+		}
+	}
+
+	l.ensurePolling()
+
+	for {
+		l.cacheMux.Lock()
+		config, isCached := l.cache[key]
+		if isCached && !reload {
+			l.cacheMux.Unlock()
+			return config.ConfigData, nil
+		}
+
+		// only one load runs for each key at a time, and the lock isn't held while it
+		// runs, so a slow source doesn't hold up callers loading other configs
+		pending, isLoading := l.loading[key]
+		if !isLoading {
+			break
+		}
+		l.cacheMux.Unlock()
+
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the load is run with its caller's context, so if that caller gave up,
+		// try again rather than failing everyone else who was waiting for it
+		if isContextError(pending.err) && ctx.Err() == nil {
+			continue
+		}
+
+		return pending.configData, pending.err
+	}
+
+	pending := &pendingLoad{done: make(chan struct{})}
+	if l.loading == nil {
+		l.loading = make(map[interface{}]*pendingLoad)
+	}
+	l.loading[key] = pending
+	closes := l.closes
+	l.cacheMux.Unlock()
+
+	defer close(pending.done)
+
+	withSources := l.withSources(sources())
+
+	// take versions before reading, so a change made while
+	// we're loading is picked up by the next poll
+	versions := sourceVersions(ctx, withSources)
+
+	pending.configData, pending.err = load(ctx, withSources)
+
+	l.cacheMux.Lock()
+	delete(l.loading, key)
+
+	if pending.err != nil {
+		l.cacheMux.Unlock()
+		return nil, pending.err
+	}
+
+	var config *cachedConfig
+	if l.closes == closes {
+		if old, isCached := l.cache[key]; isCached {
+			close(old.stopWatching)
+		}
+
+		config = l.store(key, withSources, versions, pending.configData, load, stored)
+		if stored != nil {
+			stored(pending.configData)
+		}
+	}
+	l.cacheMux.Unlock()
+
+	if config != nil {
+		l.watch(key, config)
+	}
+
+	return pending.configData, nil
+}
+
+// store caches configData under key. It must be called with cacheMux held, and once it's
+// released, watch must be called with the config it returns.
+func (l *Loader) store(key interface{}, sources []Source, versions []string, configData interface{}, load func(context.Context, []Source) (interface{}, error), stored func(interface{})) *cachedConfig {
+	config := &cachedConfig{
+		Sources:      sources,
+		Versions:     versions,
+		Watched:      make([]bool, len(sources)),
+		ConfigData:   configData,
+		load:         load,
+		stored:       stored,
+		stopWatching: make(chan struct{}),
+	}
+
+	if l.cache == nil {
+		l.cache = make(map[interface{}]*cachedConfig)
+	}
+	l.cache[key] = config
+
+	if l.reloadContext == nil {
+		l.reloadContext, l.cancelReloads = context.WithCancel(context.Background())
+	}
+
+	// Close waits for watch to finish
+	l.background.Add(1)
+
+	return config
+}
+
+// watch starts watching the sources of config, which store cached under key. Watching
+// a source can mean reading it, so it's done without cacheMux held, and its sources are
+// polled until then. If config is replaced in the meantime, watch waits for its sources
+// to stop being watched.
+func (l *Loader) watch(key interface{}, config *cachedConfig) {
+	defer l.background.Done()
+
+	watched := make([]bool, len(config.Sources))
+	dones := make([]<-chan struct{}, 0, len(config.Sources))
+
+	for i, source := range config.Sources {
+		watchable, ok := source.(WatchableSource)
+		if !ok {
+			continue
+		}
+
+		done, err := watchable.Watch(config.Versions[i], config.stopWatching, func() {
+			l.reload(key, config)
+		})
+		if err != nil {
+			continue
+		}
+
+		watched[i] = true
+		if done != nil {
+			dones = append(dones, done)
+		}
+	}
+
+	l.cacheMux.Lock()
+	current := l.cache[key] == config
+	if current {
+		copy(config.Watched, watched)
+
+		// Close waits for the sources to stop being watched
+		for _, done := range dones {
+			l.background.Add(1)
+			go func(done <-chan struct{}) {
+				defer l.background.Done()
+				<-done
+			}(done)
+		}
+	}
+	l.cacheMux.Unlock()
+
+	// config has already stopped being watched
+	if !current {
+		for _, done := range dones {
+			<-done
+		}
+	}
+}
+
+// reload reloads config in the background after one of its sources changed, and notifies
+// subscribers. If the config can't be reloaded, the last good config is kept and the error
+// is reported to subscribers instead, unless its primary config no longer exists, in which
+// case it is evicted from the cache.
+func (l *Loader) reload(key interface{}, config *cachedConfig) {
+	l.cacheMux.Lock()
+	if l.cache[key] != config || config.reloading {
+		l.cacheMux.Unlock()
+		return
+	}
+	config.reloading = true
+	ctx := l.reloadContext
+	l.background.Add(1)
+	l.cacheMux.Unlock()
+
+	defer l.background.Done()
+
+	versions := sourceVersions(ctx, config.Sources)
+	configData, err := config.load(ctx, config.Sources)
+
+	l.cacheMux.Lock()
+	if l.cache[key] != config {
+		l.cacheMux.Unlock()
+		return
+	}
+
+	close(config.stopWatching)
+
+	// keep the new versions even if the config couldn't be loaded,
+	// so we don't try again until the sources change again
+	var newConfig *cachedConfig
+	if errors.Is(err, ErrPrimaryConfigFileNotExist) {
+		delete(l.cache, key)
+		if config.stored != nil {
+			config.stored(nil)
+		}
+	} else if err != nil {
+		newConfig = l.store(key, config.Sources, versions, config.ConfigData, config.load, config.stored)
+	} else {
+		newConfig = l.store(key, config.Sources, versions, configData, config.load, config.stored)
+		if config.stored != nil {
+			config.stored(configData)
+		}
+	}
+	l.cacheMux.Unlock()
+
+	if newConfig != nil {
+		l.watch(key, newConfig)
+	}
+
+	if err != nil {
+		l.notifyError(&ReloadError{Key: key, Err: err})
+		return
+	}
+
+	// a source can change without the config changing, e.g. a file being touched
+	changed := changedPaths(config.ConfigData, configData)
+	if len(changed) == 0 {
+		return
+	}
+
+	l.notify(ReloadEvent{
+		Key:     key,
+		Old:     config.ConfigData,
+		New:     configData,
+		Changed: changed,
+	})
+}
+
+// Close stops the Loader polling and watching for changes, interrupts any reloads in
+// progress, and waits for them, and the goroutines watching its sources, to finish. Its
+// cache is emptied, so configs are loaded afresh if the Loader is used again, which starts
+// it up again.
+func (l *Loader) Close() {
+	l.cacheMux.Lock()
+	if l.cancelReloads != nil {
+		l.cancelReloads()
+		l.reloadContext, l.cancelReloads = nil, nil
+	}
+
+	for _, config := range l.cache {
+		close(config.stopWatching)
+		if config.stored != nil {
+			config.stored(nil)
+		}
+	}
+	l.cache = nil
+	l.closes++
+	l.cacheMux.Unlock()
+
+	l.pollingMux.Lock()
+	if l.pollingTicker != nil {
+		l.stopPolling <- true
+		l.pollingTicker.Stop()
+		l.pollingTicker = nil
+	}
+	l.pollingMux.Unlock()
+
+	l.background.Wait()
+}
+
+// isContextError reports whether err is the result of a context
+// being cancelled or its deadline passing
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func sourceVersions(ctx context.Context, sources []Source) []string {
+	versions := make([]string, len(sources))
+	for i, source := range sources {
+		versions[i] = sourceVersion(ctx, source)
+	}
+	return versions
+}
+
+// sourceVersion returns the Version of source, or "" if it can't be read
+func sourceVersion(ctx context.Context, source Source) string {
+	var version string
+	var err error
+
+	if contextSource, ok := source.(ContextSource); ok {
+		version, err = contextSource.VersionContext(ctx)
+	} else {
+		version, err = source.Version()
+	}
+	if err != nil {
+		return ""
+	}
+	return version
+}
@@ -5,12 +5,14 @@
 package transfig
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io"
+	"io/fs"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,26 +25,18 @@ var ErrPrimaryConfigFileNotExist = fmt.Errorf("config: primary config file does
 // struct to pass config data into is not a pointer
 var ErrConfigDataNotPointer = fmt.Errorf("config: configData argument is not a pointer")
 
-type configFile struct {
-	Path                string
-	PathLastModified    time.Time
-	AltExists           bool
-	AltPath             string
-	AltPathLastModified time.Time
-	ConfigData          interface{}
-}
+// ErrFSNotCacheable is returned by LoadWithCachingFS when fsys holds a map, slice or func
+// (e.g. a struct wrapping a fstest.MapFS), so can't identify the cached config. Pass a
+// pointer to it instead, or use LoadSourcesWithCaching with a key of your own.
+var ErrFSNotCacheable = fmt.Errorf("config: fsys cannot be used as a cache key")
 
-var (
-	cache    = make(map[string]configFile)
-	cacheMux = sync.RWMutex{}
-)
+var defaultLoader = &Loader{}
 
 var (
-	// ReloadPollingInterval sets how often config files are checked for changes
-	ReloadPollingInterval = time.Duration(time.Second * 5)
+	// ReloadPollingInterval is how often the package level LoadWithCaching checks config
+	// files for changes. Use SetReloadPollingInterval to change it.
+	ReloadPollingInterval = DefaultReloadPollingInterval
 	pollingIntervalMutex  = sync.Mutex{}
-	stopPolling           = make(chan bool)
-	pollingTicker         *time.Ticker
 )
 
 // SetReloadPollingInterval determines how often we should
@@ -52,125 +46,89 @@ func SetReloadPollingInterval(duration time.Duration) {
 	defer pollingIntervalMutex.Unlock()
 
 	ReloadPollingInterval = duration
-
-	if pollingTicker != nil {
-		stopPolling <- true
-		pollingTicker.Stop()
-		pollingTicker = nil
-	}
-
-	pollingTicker = time.NewTicker(ReloadPollingInterval)
-
-	go func(ticker *time.Ticker) {
-		for {
-			select {
-			case <-ticker.C:
-				for key, config := range cache {
-					pathInfo, err := os.Stat(config.Path)
-					if err != nil {
-						break
-					}
-
-					var altPathInfo os.FileInfo
-					if config.AltExists {
-						altPathInfo, err = os.Stat(config.AltPath)
-						if err != nil {
-							break
-						}
-					}
-
-					if pathInfo.ModTime().After(config.PathLastModified) ||
-						(config.AltExists && altPathInfo.ModTime().After(config.AltPathLastModified)) {
-						cacheMux.Lock()
-						delete(cache, key)
-						cacheMux.Unlock()
-					}
-				}
-			case <-stopPolling:
-				return
-			}
-		}
-	}(pollingTicker)
+	defaultLoader.SetReloadPollingInterval(duration)
 }
 
-func init() {
-	SetReloadPollingInterval(time.Duration(time.Second * 5))
+// Close stops the package level functions checking cached configs for changes, and waits
+// for any reloads in progress to finish, so that tests and short lived programs don't leave
+// goroutines running. See Loader.Close.
+func Close() {
+	defaultLoader.Close()
 }
 
 // LoadWithCaching will load a configuration json file into a struct with built in support for caching
 func LoadWithCaching(path, environment string, configData interface{}) error {
-	cacheMux.RLock()
-
-	cacheKey := path + "_" + environment
-	cachedConfig, isCached := cache[cacheKey]
+	return defaultLoader.LoadWithCaching(path, environment, configData)
+}
 
-	if isCached {
-		value := reflect.ValueOf(cachedConfig.ConfigData)
-		reflect.ValueOf(configData).Elem().Set(value)
-		cacheMux.RUnlock()
-		return nil
-	}
+// LoadWithCachingContext is like LoadWithCaching, but if the config isn't
+// cached, stops loading it once ctx is done.
+func LoadWithCachingContext(ctx context.Context, path, environment string, configData interface{}) error {
+	return defaultLoader.LoadWithCachingContext(ctx, path, environment, configData)
+}
 
-	cacheMux.RUnlock()
+// LoadWithCachingFS is like LoadWithCaching, but reads the primary and environment
+// config files from fsys. Changes are only picked up if fsys reports modification times.
+// It returns ErrFSNotCacheable if fsys can't be used to identify the cached config.
+func LoadWithCachingFS(fsys fs.FS, path, environment string, configData interface{}) error {
+	return defaultLoader.LoadWithCachingFS(fsys, path, environment, configData)
+}
 
-	cacheMux.Lock()
-	defer cacheMux.Unlock()
+// Load will load a configuration json file into a struct. path may also be a directory
+// of config fragments (e.g. "config.d"), in which case every *.json file in it is merged
+// in lexical order, with the fragments in the environment subdirectory (e.g. "config.d/live")
+// applied over the top.
+func Load(path, environment string, configData interface{}) error {
+	return defaultLoader.Load(path, environment, configData)
+}
 
-	cachedConfig, isCached = cache[cacheKey]
-	if isCached {
-		value := reflect.ValueOf(cachedConfig.ConfigData)
-		reflect.ValueOf(configData).Elem().Set(value)
-		return nil
-	}
+// LoadContext is like Load, but stops loading once ctx is done, e.g. because its deadline
+// passed. Sources that can be read using a context, such as an HTTPSource, honour ctx.
+func LoadContext(ctx context.Context, path, environment string, configData interface{}) error {
+	return defaultLoader.LoadContext(ctx, path, environment, configData)
+}
 
-	err := Load(path, environment, configData)
-	if err != nil {
-		return err
-	}
+// LoadFS will load a configuration json file from fsys into a struct. This allows
+// config files to be embedded using go:embed, or supplied by a testing/fstest.MapFS.
+// As with Load, path may also be a directory of config fragments.
+func LoadFS(fsys fs.FS, path, environment string, configData interface{}) error {
+	return defaultLoader.LoadFS(fsys, path, environment, configData)
+}
 
-	pathInfo, err := os.Stat(path)
-	if err != nil {
-		return err
-	}
+// LoadReader will load a primary configuration json document from primary into a struct,
+// then apply the environment json document from environment over the top of it.
+// environment may be nil if there are no environment specific settings.
+func LoadReader(primary, environment io.Reader, configData interface{}) error {
+	return defaultLoader.LoadReader(primary, environment, configData)
+}
 
-	altExists := true
-	altPath := generateEnvPath(path, environment)
-	altPathInfo, err := os.Stat(altPath)
-	if os.IsNotExist(err) {
-		altExists = false
-	} else if err != nil {
-		return err
-	}
+// LoadBytes will load a primary configuration json document into a struct, then apply
+// the environment json document over the top of it. envData may be nil if there are
+// no environment specific settings.
+func LoadBytes(data, envData []byte, configData interface{}) error {
+	return defaultLoader.LoadBytes(data, envData, configData)
+}
 
-	configInfo := configFile{
-		Path:             path,
-		PathLastModified: pathInfo.ModTime(),
-		AltExists:        altExists,
-		ConfigData:       reflect.ValueOf(configData).Elem().Interface(),
+func readDocuments(primary, environment io.Reader) (data, envData []byte, err error) {
+	data, err = io.ReadAll(primary)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: error reading primary config: %s", err)
 	}
 
-	if altExists {
-		configInfo.AltPath = altPath
-		configInfo.AltPathLastModified = altPathInfo.ModTime()
+	if environment != nil {
+		envData, err = io.ReadAll(environment)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: error reading environment config: %s", err)
+		}
 	}
 
-	cache[cacheKey] = configInfo
-
-	return nil
+	return data, envData, nil
 }
 
-// Load will load a configuration json file into a struct
-func Load(path, environment string, configData interface{}) (err error) {
-	if reflect.TypeOf(configData).Kind() != reflect.Ptr {
-		return ErrConfigDataNotPointer
-	}
-
-	// process primary config file
-	data, err := ioutil.ReadFile(path)
-	if os.IsNotExist(err) {
-		return ErrPrimaryConfigFileNotExist
-	} else if err != nil {
-		return fmt.Errorf("config: error opening primary config file: %s", err)
+func decodePrimary(data []byte, configData interface{}) error {
+	err := applyDefaults(reflect.ValueOf(configData).Elem(), decodeLayer(data))
+	if err != nil {
+		return err
 	}
 
 	dataNoComments := stripComments(data)
@@ -180,18 +138,10 @@ func Load(path, environment string, configData interface{}) (err error) {
 		return fmt.Errorf("config: cannot unmarshal config file: %s", err)
 	}
 
-	// process environment specific config file
-	envPath := generateEnvPath(path, environment)
-
-	envData, err := ioutil.ReadFile(envPath)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("config: error opening environment config file \"%s\": %s", envPath, err)
-	}
-
-	if os.IsNotExist(err) {
-		return nil
-	}
+	return nil
+}
 
+func applyEnvironment(envData []byte, configValue reflect.Value) (err error) {
 	envDataNoComments := stripComments(envData)
 
 	envConfigData := map[string]interface{}{}
@@ -206,7 +156,6 @@ func Load(path, environment string, configData interface{}) (err error) {
 		}
 	}()
 
-	configValue := reflect.ValueOf(configData).Elem()
 	parseMap(envConfigData, configValue)
 
 	return
@@ -217,6 +166,11 @@ func generateEnvPath(path, environment string) string {
 }
 
 func parseMap(aMap map[string]interface{}, configValue reflect.Value) {
+	if configValue.Kind() == reflect.Map {
+		parseMapEntries(aMap, configValue)
+		return
+	}
+
 	for key, value := range aMap {
 		fieldName := ""
 
@@ -234,33 +188,41 @@ func parseMap(aMap map[string]interface{}, configValue reflect.Value) {
 			continue
 		}
 
-		switch realValue := value.(type) {
-		case map[string]interface{}:
-			if fieldValue.Kind() == reflect.Struct || fieldValue.Kind() == reflect.Map {
-				parseMap(realValue, fieldValue)
-			}
-		case []interface{}:
-			if fieldValue.Kind() == reflect.Slice {
-				parseSlice(realValue, fieldValue)
-			}
-		case string:
-			if fieldValue.Kind() == reflect.String {
-				fieldValue.SetString(realValue)
-			}
-		case float64:
-			switch fieldValue.Kind() {
-			case reflect.Float32, reflect.Float64:
-				fieldValue.SetFloat(realValue)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				fieldValue.SetInt(int64(realValue))
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				fieldValue.SetUint(uint64(realValue))
-			}
-		case bool:
-			if fieldValue.Kind() == reflect.Bool {
-				fieldValue.SetBool(realValue)
-			}
+		parseValue(value, fieldValue)
+	}
+}
+
+// parseMapEntries sets the entries of the map configValue from aMap, leaving any other
+// entries as they are. Entries that are objects are merged into the existing entry.
+func parseMapEntries(aMap map[string]interface{}, configValue reflect.Value) {
+	mapType := configValue.Type()
+	if mapType.Key().Kind() != reflect.String {
+		return
+	}
+
+	if configValue.IsNil() {
+		configValue.Set(reflect.MakeMap(mapType))
+	}
+
+	for key, value := range aMap {
+		if value == nil {
+			continue
+		}
+
+		mapKey := reflect.ValueOf(key).Convert(mapType.Key())
+
+		// map entries can't be set in place, so set a copy and store it
+		entry := reflect.New(mapType.Elem()).Elem()
+		if existing := configValue.MapIndex(mapKey); existing.IsValid() {
+			entry.Set(existing)
+		}
+
+		if entry.Kind() == reflect.Interface {
+			entry.Set(reflect.ValueOf(value))
+		} else {
+			parseValue(value, entry)
 		}
+		configValue.SetMapIndex(mapKey, entry)
 	}
 }
 
@@ -269,38 +231,85 @@ func parseSlice(aSlice []interface{}, configValue reflect.Value) {
 	configValue.Set(newSlice)
 
 	for i, value := range aSlice {
-		configItem := configValue.Index(i)
+		parseValue(value, configValue.Index(i))
+	}
+}
 
-		switch realItem := value.(type) {
-		case map[string]interface{}:
-			if configItem.Kind() == reflect.Struct || configItem.Kind() == reflect.Map {
-				parseMap(realItem, configItem)
-			}
-		case []interface{}:
-			if configItem.Kind() == reflect.Slice {
-				parseSlice(realItem, configItem)
-			}
-		case string:
-			if configItem.Kind() == reflect.String {
-				configItem.SetString(realItem)
-			}
-		case float64:
-			switch configItem.Kind() {
-			case reflect.Float32, reflect.Float64:
-				configItem.SetFloat(realItem)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				configItem.SetInt(int64(realItem))
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				configItem.SetUint(uint64(realItem))
-			}
-		case bool:
-			if configItem.Kind() == reflect.Bool {
-				configItem.SetBool(realItem)
+// parseValue sets configValue from a value decoded from an environment config.
+// Values of the wrong type are ignored.
+func parseValue(value interface{}, configValue reflect.Value) {
+	switch realValue := value.(type) {
+	case map[string]interface{}:
+		if configValue.Kind() == reflect.Struct || configValue.Kind() == reflect.Map {
+			parseMap(realValue, configValue)
+		}
+	case []interface{}:
+		if configValue.Kind() == reflect.Slice {
+			parseSlice(realValue, configValue)
+		}
+	case string:
+		parseString(realValue, configValue)
+	case float64:
+		switch configValue.Kind() {
+		case reflect.Float32, reflect.Float64:
+			configValue.SetFloat(realValue)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			configValue.SetInt(int64(realValue))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			configValue.SetUint(uint64(realValue))
+		}
+	case bool:
+		if configValue.Kind() == reflect.Bool {
+			configValue.SetBool(realValue)
+		}
+	}
+}
+
+// parseString sets configValue from a string, converting it when configValue isn't a
+// string field. This lets sources that only deal in strings, such as key/value stores,
+// set numeric, boolean, duration and even slice or struct fields (as JSON).
+// Strings that can't be converted are ignored, just like any other mismatched type.
+func parseString(aString string, configValue reflect.Value) {
+	switch configValue.Kind() {
+	case reflect.String:
+		configValue.SetString(aString)
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(aString); err == nil {
+			configValue.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if configValue.Type() == durationType {
+			if d, err := time.ParseDuration(aString); err == nil {
+				configValue.SetInt(int64(d))
+				return
 			}
 		}
+		if i, err := strconv.ParseInt(aString, 10, configValue.Type().Bits()); err == nil {
+			configValue.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u, err := strconv.ParseUint(aString, 10, configValue.Type().Bits()); err == nil {
+			configValue.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(aString, configValue.Type().Bits()); err == nil {
+			configValue.SetFloat(f)
+		}
+	case reflect.Struct, reflect.Map:
+		var aMap map[string]interface{}
+		if json.Unmarshal([]byte(aString), &aMap) == nil {
+			parseMap(aMap, configValue)
+		}
+	case reflect.Slice:
+		var aSlice []interface{}
+		if json.Unmarshal([]byte(aString), &aSlice) == nil {
+			parseSlice(aSlice, configValue)
+		}
 	}
 }
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 var stripCommentsRegex = regexp.MustCompile(`\ *\/\/.+\n`)
 
 func stripComments(jsonText []byte) []byte {
@@ -0,0 +1,154 @@
+package transfig
+
+// ReloadEvent describes a cached config that was reloaded in the background
+// because one of its sources changed. Reloads that don't change any values,
+// e.g. because a config file was saved without being edited, aren't reported.
+type ReloadEvent struct {
+	// Key identifies the config that was reloaded. It is a FileKey for configs cached by
+	// LoadWithCaching, the key passed to LoadSourcesWithCaching, or the *Config[T].
+	Key interface{}
+
+	// Old and New are the config before and after it was reloaded, of the same type as
+	// the struct the config was loaded into. Each subscriber gets its own copies, so they
+	// can be modified without affecting the cached config or other subscribers.
+	Old interface{}
+	New interface{}
+
+	// Changed holds the JSON path of every value that changed, e.g.
+	// "database.connectionString" or "servers[1].host".
+	Changed []string
+}
+
+// ReloadError is reported when a cached config couldn't be reloaded in the background, e.g.
+// because a config file was edited into invalid JSON. The last good config is kept, and is
+// still returned when the config is loaded, until its sources are fixed.
+type ReloadError struct {
+	// Key identifies the config that couldn't be reloaded, as with ReloadEvent.
+	Key interface{}
+
+	Err error
+}
+
+func (e *ReloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
+type subscriber struct {
+	fn      func(ReloadEvent)
+	onError func(*ReloadError)
+}
+
+// Subscribe calls fn whenever a config cached by the Loader is reloaded in the background,
+// so the application can react straight away, e.g. by resizing a connection pool. fn is
+// called from the goroutine that noticed the change, so it shouldn't block for long.
+// The returned function unsubscribes fn.
+func (l *Loader) Subscribe(fn func(ReloadEvent)) (unsubscribe func()) {
+	return l.subscribe(&subscriber{fn: fn})
+}
+
+// SubscribeErrors calls fn whenever a config cached by the Loader can't be reloaded in the
+// background, e.g. so the error can be logged. The last good config carries on being used,
+// and the config is reloaded again the next time its sources change. The returned function
+// unsubscribes fn.
+func (l *Loader) SubscribeErrors(fn func(*ReloadError)) (unsubscribe func()) {
+	return l.subscribe(&subscriber{onError: fn})
+}
+
+func (l *Loader) subscribe(sub *subscriber) (unsubscribe func()) {
+	l.subscribersMux.Lock()
+	if l.subscribers == nil {
+		l.subscribers = make(map[*subscriber]struct{})
+	}
+	l.subscribers[sub] = struct{}{}
+	l.subscribersMux.Unlock()
+
+	return func() {
+		l.subscribersMux.Lock()
+		delete(l.subscribers, sub)
+		l.subscribersMux.Unlock()
+	}
+}
+
+// Notify sends a ReloadEvent on ch whenever a config cached by the Loader is reloaded in
+// the background. As with signal.Notify, sends don't block, so events are dropped if ch
+// isn't ready; use a buffered channel. The returned function stops the notifications.
+func (l *Loader) Notify(ch chan<- ReloadEvent) (stop func()) {
+	return l.Subscribe(func(event ReloadEvent) {
+		select {
+		case ch <- event:
+		default:
+		}
+	})
+}
+
+// NotifyErrors sends a *ReloadError on ch whenever a config cached by the Loader can't be
+// reloaded in the background. As with Notify, sends don't block. The returned function
+// stops the notifications.
+func (l *Loader) NotifyErrors(ch chan<- error) (stop func()) {
+	return l.SubscribeErrors(func(err *ReloadError) {
+		select {
+		case ch <- err:
+		default:
+		}
+	})
+}
+
+func (l *Loader) notify(event ReloadEvent) {
+	for _, sub := range l.subscribersSnapshot() {
+		if sub.fn != nil {
+			sub.fn(ReloadEvent{
+				Key:     event.Key,
+				Old:     copyConfig(event.Old),
+				New:     copyConfig(event.New),
+				Changed: append([]string(nil), event.Changed...),
+			})
+		}
+	}
+}
+
+func (l *Loader) notifyError(err *ReloadError) {
+	for _, sub := range l.subscribersSnapshot() {
+		if sub.onError != nil {
+			sub.onError(err)
+		}
+	}
+}
+
+func (l *Loader) subscribersSnapshot() []*subscriber {
+	l.subscribersMux.RLock()
+	defer l.subscribersMux.RUnlock()
+
+	subscribers := make([]*subscriber, 0, len(l.subscribers))
+	for sub := range l.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers
+}
+
+// Subscribe calls fn whenever a config cached by the package level functions
+// is reloaded. See Loader.Subscribe.
+func Subscribe(fn func(ReloadEvent)) (unsubscribe func()) {
+	return defaultLoader.Subscribe(fn)
+}
+
+// Notify sends a ReloadEvent on ch whenever a config cached by the package level
+// functions is reloaded. See Loader.Notify.
+func Notify(ch chan<- ReloadEvent) (stop func()) {
+	return defaultLoader.Notify(ch)
+}
+
+// SubscribeErrors calls fn whenever a config cached by the package level functions
+// can't be reloaded. See Loader.SubscribeErrors.
+func SubscribeErrors(fn func(*ReloadError)) (unsubscribe func()) {
+	return defaultLoader.SubscribeErrors(fn)
+}
+
+// NotifyErrors sends a *ReloadError on ch whenever a config cached by the package level
+// functions can't be reloaded. See Loader.NotifyErrors.
+func NotifyErrors(ch chan<- error) (stop func()) {
+	return defaultLoader.NotifyErrors(ch)
+}
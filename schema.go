@@ -0,0 +1,350 @@
+package transfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// descriptionTagName is the struct tag holding a field's description in the generated
+// JSON Schema, which editors show alongside autocomplete suggestions.
+const descriptionTagName = "description"
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var timeType = reflect.TypeOf(time.Time{})
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Schema returns a JSON Schema (draft 2020-12) describing the primary config file for
+// configData, a struct or a pointer to one, so that editors can offer autocomplete and
+// validation while editing config.json. It is built from the struct's json tags and field
+// types, along with its default, description and transfig validation tags.
+func Schema(configData interface{}) ([]byte, error) {
+	return generateSchema(configData, false)
+}
+
+// EnvironmentSchema is like Schema, but describes an environment config file. Environment
+// config files only need to set the values they override, so every property is optional,
+// and settings may also be written as strings, which are converted just as they are when
+// the environment config is applied.
+func EnvironmentSchema(configData interface{}) ([]byte, error) {
+	return generateSchema(configData, true)
+}
+
+func generateSchema(configData interface{}, environment bool) ([]byte, error) {
+	configType := reflect.TypeOf(configData)
+	for configType != nil && configType.Kind() == reflect.Ptr {
+		configType = configType.Elem()
+	}
+
+	if configType == nil || configType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: can only generate a schema for a struct, not %v", configType)
+	}
+
+	generator := schemaGenerator{
+		environment: environment,
+		seen:        map[reflect.Type]bool{},
+	}
+
+	root, err := generator.schemaFor(configType)
+	if err != nil {
+		return nil, err
+	}
+
+	root.Schema = schemaDialect
+	root.Title = configType.Name()
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// jsonSchema is the subset of JSON Schema that config structs are described with
+type jsonSchema struct {
+	Schema               string            `json:"$schema,omitempty"`
+	Title                string            `json:"title,omitempty"`
+	Description          string            `json:"description,omitempty"`
+	Type                 interface{}       `json:"type,omitempty"`
+	Format               string            `json:"format,omitempty"`
+	Properties           *schemaProperties `json:"properties,omitempty"`
+	Required             []string          `json:"required,omitempty"`
+	Items                *jsonSchema       `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema       `json:"additionalProperties,omitempty"`
+	Default              interface{}       `json:"default,omitempty"`
+	Enum                 []interface{}     `json:"enum,omitempty"`
+	Pattern              string            `json:"pattern,omitempty"`
+	Minimum              *float64          `json:"minimum,omitempty"`
+	Maximum              *float64          `json:"maximum,omitempty"`
+	MinLength            *int              `json:"minLength,omitempty"`
+	MaxLength            *int              `json:"maxLength,omitempty"`
+	MinItems             *int              `json:"minItems,omitempty"`
+	MaxItems             *int              `json:"maxItems,omitempty"`
+	MinProperties        *int              `json:"minProperties,omitempty"`
+	MaxProperties        *int              `json:"maxProperties,omitempty"`
+}
+
+// schemaProperties keeps properties in the order their fields are declared in
+type schemaProperties struct {
+	names   []string
+	schemas map[string]*jsonSchema
+}
+
+func (p *schemaProperties) add(name string, schema *jsonSchema) {
+	if _, exists := p.schemas[name]; !exists {
+		p.names = append(p.names, name)
+	}
+	p.schemas[name] = schema
+}
+
+func (p *schemaProperties) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+
+	for i, name := range p.names {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(p.schemas[name])
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+type schemaGenerator struct {
+	environment bool
+
+	// seen holds the struct types being described, so recursive types don't recurse forever
+	seen map[reflect.Type]bool
+}
+
+func (g *schemaGenerator) schemaFor(valueType reflect.Type) (*jsonSchema, error) {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch {
+	case valueType == durationType:
+		// encoding/json only decodes durations from nanoseconds, but environment
+		// configs also accept duration strings such as "30s"
+		return &jsonSchema{Type: g.scalarType("integer")}, nil
+	case valueType == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}, nil
+	case !reflect.PointerTo(valueType).Implements(jsonUnmarshalerType) &&
+		reflect.PointerTo(valueType).Implements(textUnmarshalerType):
+		return &jsonSchema{Type: "string"}, nil
+	}
+
+	switch valueType.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &jsonSchema{Type: g.scalarType("boolean")}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: g.scalarType("integer")}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := float64(0)
+		return &jsonSchema{Type: g.scalarType("integer"), Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: g.scalarType("number")}, nil
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices as base64 strings
+			return &jsonSchema{Type: "string"}, nil
+		}
+
+		items, err := g.schemaFor(valueType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		values, err := g.schemaFor(valueType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		return g.structSchema(valueType)
+	}
+
+	// interfaces, and anything else encoding/json can't describe, accept any value
+	return &jsonSchema{}, nil
+}
+
+// scalarType returns the JSON type of a bool or number. Environment configs
+// also accept strings, which are converted to the field's type.
+func (g *schemaGenerator) scalarType(jsonType string) interface{} {
+	if g.environment {
+		return []string{jsonType, "string"}
+	}
+	return jsonType
+}
+
+func (g *schemaGenerator) structSchema(structType reflect.Type) (*jsonSchema, error) {
+	if g.seen[structType] {
+		return &jsonSchema{Type: "object"}, nil
+	}
+	g.seen[structType] = true
+	defer delete(g.seen, structType)
+
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: &schemaProperties{schemas: map[string]*jsonSchema{}},
+	}
+
+	err := g.addFields(schema, structType)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+func (g *schemaGenerator) addFields(schema *jsonSchema, structType reflect.Type) error {
+	for i := 0; i < structType.NumField(); i++ {
+		fieldInfo := structType.Field(i)
+		if fieldInfo.PkgPath != "" && !fieldInfo.Anonymous {
+			continue
+		}
+
+		name := jsonFieldName(fieldInfo)
+		if name == "-" {
+			continue
+		}
+
+		// encoding/json flattens embedded structs into their parent, even unexported ones
+		fieldType := fieldInfo.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldInfo.Anonymous && fieldInfo.Tag.Get("json") == "" && fieldType.Kind() == reflect.Struct {
+			err := g.addFields(schema, fieldType)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if fieldInfo.PkgPath != "" {
+			continue
+		}
+
+		fieldSchema, err := g.schemaFor(fieldInfo.Type)
+		if err != nil {
+			return err
+		}
+
+		fieldSchema.Description = fieldInfo.Tag.Get(descriptionTagName)
+
+		defaultText, hasDefault := fieldInfo.Tag.Lookup(defaultTagName)
+		if hasDefault {
+			value, err := parseDefault(defaultText, fieldInfo.Type)
+			if err != nil {
+				return fmt.Errorf("config: invalid default for %s.%s: %s", structType, fieldInfo.Name, err)
+			}
+			fieldSchema.Default = value.Interface()
+		}
+
+		rules, err := parseRules(fieldInfo.Tag.Get(tagName))
+		if err != nil {
+			return fmt.Errorf("config: invalid %s tag on %s.%s: %s", tagName, structType, fieldInfo.Name, err)
+		}
+
+		for _, rule := range rules {
+			// a field with a default doesn't need to be in the config file
+			if rule.name == "required" && !g.environment && !hasDefault {
+				schema.Required = append(schema.Required, name)
+			}
+
+			err := g.applyRule(fieldSchema, rule, fieldInfo.Type)
+			if err != nil {
+				return fmt.Errorf("config: invalid %s tag on %s.%s: %s", tagName, structType, fieldInfo.Name, err)
+			}
+		}
+
+		schema.Properties.add(name, fieldSchema)
+	}
+
+	return nil
+}
+
+// applyRule adds the JSON Schema keywords equivalent to a transfig validation rule.
+// Rules without an equivalent, such as envRequired, are left out.
+func (g *schemaGenerator) applyRule(schema *jsonSchema, rule rule, valueType reflect.Type) error {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch rule.name {
+	case "nonempty":
+		one := 1
+		switch schema.Type {
+		case "string":
+			schema.MinLength = &one
+		case "array":
+			schema.MinItems = &one
+		case "object":
+			schema.MinProperties = &one
+		}
+	case "min", "max":
+		bound, err := rule.parseBound(reflect.New(valueType).Elem())
+		if err != nil {
+			return err
+		}
+
+		length := int(bound)
+		switch {
+		case schema.Type == "string":
+			setBound(rule.name, &schema.MinLength, &schema.MaxLength, &length)
+		case schema.Type == "array":
+			setBound(rule.name, &schema.MinItems, &schema.MaxItems, &length)
+		case schema.Type == "object":
+			setBound(rule.name, &schema.MinProperties, &schema.MaxProperties, &length)
+		default:
+			setBound(rule.name, &schema.Minimum, &schema.Maximum, &bound)
+		}
+	case "oneof":
+		for _, allowed := range strings.Fields(rule.arg) {
+			value, err := parseDefault(allowed, valueType)
+			if err != nil {
+				return err
+			}
+			schema.Enum = append(schema.Enum, value.Interface())
+
+			// environment configs may write the value as a string
+			if g.environment && valueType.Kind() != reflect.String {
+				schema.Enum = append(schema.Enum, allowed)
+			}
+		}
+	case "regexp":
+		schema.Pattern = rule.arg
+	case "url":
+		schema.Format = "uri"
+	case "hostport":
+		schema.Pattern = `^.*:[0-9]+$`
+	}
+
+	return nil
+}
+
+func setBound[V any](name string, min, max **V, value *V) {
+	if name == "min" {
+		*min = value
+	} else {
+		*max = value
+	}
+}
@@ -0,0 +1,300 @@
+package transfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Source provides the raw JSON document for one layer of configuration, such as
+// a file on disk, a remote URL or a key/value store.
+type Source interface {
+	// Read returns the JSON document. A source that doesn't exist returns an
+	// error satisfying errors.Is(err, fs.ErrNotExist).
+	Read() ([]byte, error)
+
+	// Version returns a value that changes whenever the document does, such as
+	// a modification time, a revision number or an ETag. It is polled to decide
+	// when a cached config needs reloading.
+	Version() (string, error)
+}
+
+// WatchableSource is a Source that can report its own changes, rather than
+// having its Version polled.
+type WatchableSource interface {
+	Source
+
+	// Watch starts watching the source in the background, calling changed
+	// whenever the source changes, until stop is closed. version is the Version
+	// the source had when it was loaded, so if it has already changed by the time
+	// it's being watched, changed should be called straight away. changed may be
+	// called as soon as watching has started, even before Watch returns. done is
+	// closed once watching has stopped and changed won't be called again, so that
+	// closing a Loader can wait for it.
+	// If the source can't be watched, Watch returns an error and its Version
+	// is polled instead.
+	Watch(version string, stop <-chan struct{}, changed func()) (done <-chan struct{}, err error)
+}
+
+// ContextSource is a Source that can be read using a context, so that reading it, e.g. from
+// a remote server, stops when the context is cancelled or its deadline passes.
+type ContextSource interface {
+	Source
+
+	// ReadContext is like Read, but gives up and returns the context's error
+	// if ctx is done before the document has been read.
+	ReadContext(ctx context.Context) ([]byte, error)
+
+	// VersionContext is like Version, but gives up and returns
+	// the context's error if ctx is done first.
+	VersionContext(ctx context.Context) (string, error)
+}
+
+// LoadSources will load an ordered list of sources into a struct. The first source is the
+// primary config, and must exist. Each subsequent source is applied over the top of the
+// previous ones the same way an environment config file is, and is skipped if it
+// doesn't exist.
+func LoadSources(configData interface{}, sources ...Source) error {
+	return defaultLoader.LoadSources(configData, sources...)
+}
+
+// LoadSourcesContext is like LoadSources, but stops reading the sources once ctx is done.
+// Sources that are a ContextSource, such as an HTTPSource or a KVSource, are read using ctx.
+func LoadSourcesContext(ctx context.Context, configData interface{}, sources ...Source) error {
+	return defaultLoader.LoadSourcesContext(ctx, configData, sources...)
+}
+
+func loadSources(ctx context.Context, configData interface{}, sources []Source, schema *JSONSchema) error {
+	if len(sources) == 0 {
+		return ErrPrimaryConfigFileNotExist
+	}
+
+	data, err := readSource(ctx, sources[0])
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrPrimaryConfigFileNotExist
+	} else if err != nil {
+		return fmt.Errorf("config: error opening primary config file: %w", err)
+	}
+
+	var envData [][]byte
+	for _, source := range sources[1:] {
+		layer, err := readSource(ctx, source)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("config: error opening environment config file: %w", err)
+		}
+
+		envData = append(envData, layer)
+	}
+
+	if schema != nil {
+		err = schema.check(data, envData)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = decodePrimary(data, configData)
+	if err != nil {
+		return err
+	}
+
+	configValue := reflect.ValueOf(configData).Elem()
+
+	var layers *configLayers
+	if usesLayerRules(configValue.Type()) {
+		layers = &configLayers{primary: decodeLayer(data)}
+	}
+
+	for _, layer := range envData {
+		err = applyEnvironment(layer, configValue)
+		if err != nil {
+			return err
+		}
+
+		if layers != nil {
+			layers.environments = append(layers.environments, decodeLayer(layer))
+		}
+	}
+
+	return validate(configData, layers)
+}
+
+// decodeLayer decodes a config document that has already been loaded successfully
+func decodeLayer(data []byte) interface{} {
+	var document interface{}
+	json.Unmarshal(stripComments(data), &document)
+	return document
+}
+
+// readSource reads source using ctx if it's a ContextSource. Other sources can't be
+// interrupted, but aren't read at all once ctx is done.
+func readSource(ctx context.Context, source Source) ([]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if contextSource, ok := source.(ContextSource); ok {
+		return contextSource.ReadContext(ctx)
+	}
+
+	return source.Read()
+}
+
+// LoadSourcesWithCaching is like LoadSources, but caches the result under key. The
+// cached config is reloaded when the Version of any of its sources changes, or
+// when a WatchableSource reports a change.
+func LoadSourcesWithCaching(key string, configData interface{}, sources ...Source) error {
+	return defaultLoader.LoadSourcesWithCaching(key, configData, sources...)
+}
+
+// FileSource returns a Source that reads the JSON config file at path.
+func FileSource(path string) Source {
+	return FSSource(osFS{}, path)
+}
+
+// FSSource returns a Source that reads the JSON config file at path from fsys.
+// Its Version is a hash of the file's contents, so a file that's touched but not
+// changed isn't reloaded, while one restored with an older modification time is.
+// Any files spliced in using "$include" are tracked too, so editing them also
+// changes the Version.
+func FSSource(fsys fs.FS, path string) Source {
+	return &configFile{
+		FS:   fsys,
+		Path: path,
+	}
+}
+
+// modTimeGranularity is the coarsest file modification time resolution we allow for.
+// A file modified this close to when it was last hashed may have been written again
+// within the same tick, so its size and modification time can't be trusted.
+const modTimeGranularity = time.Duration(time.Second * 2)
+
+type configFile struct {
+	FS   fs.FS
+	Path string
+
+	mu       sync.Mutex
+	read     bool
+	includes []string
+
+	// the size and modification times the hash was taken at, and when
+	stat    string
+	modTime time.Time
+	hashed  time.Time
+	hash    string
+}
+
+func (c *configFile) Read() ([]byte, error) {
+	// stat before reading, so a change made while we're reading is seen by the next Version
+	info, statErr := fs.Stat(c.FS, c.Path)
+	hashed := time.Now()
+
+	data, err := fs.ReadFile(c.FS, c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	if hasIncludes(data) {
+		data, includes, err = resolveIncludes(c.FS, c.Path, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", c.Path, err)
+		}
+	}
+
+	hash := sha256.Sum256(data)
+
+	c.mu.Lock()
+	c.read = true
+	c.includes = includes
+	c.hash = hex.EncodeToString(hash[:])
+	c.hashed = hashed
+	c.stat = ""
+	if statErr == nil {
+		c.stat, c.modTime = c.fileStat(info, includes)
+	}
+	c.mu.Unlock()
+
+	return data, nil
+}
+
+// Version is a hash of the file's contents, along with the contents of any files it includes.
+// The file is only read and hashed again if its size or modification time, or those of its
+// includes, have changed since it was last hashed, or it was modified too recently to tell.
+func (c *configFile) Version() (string, error) {
+	info, err := fs.Stat(c.FS, c.Path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	read, includes := c.read, c.includes
+	stat, modTime, hashed, hash := c.stat, c.modTime, c.hashed, c.hash
+	c.mu.Unlock()
+
+	if read && stat != "" && hashed.Sub(modTime) >= modTimeGranularity {
+		if current, _ := c.fileStat(info, includes); current == stat {
+			return hash, nil
+		}
+	}
+
+	_, err = c.Read()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hash, nil
+}
+
+// fileStat returns the size and modification time of the file and its includes, as a
+// cheap check for changes, along with the latest of their modification times.
+func (c *configFile) fileStat(info fs.FileInfo, includes []string) (string, time.Time) {
+	stat := fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+	modTime := info.ModTime()
+
+	// a swapped symlink may point at a file with the same size and modification time
+	if _, ok := c.FS.(osFS); ok {
+		if resolved, err := filepath.EvalSymlinks(c.Path); err == nil && resolved != filepath.Clean(c.Path) {
+			stat += ":" + resolved
+		}
+	}
+
+	if len(includes) > 0 {
+		version, latest := includesVersion(c.FS, includes)
+		stat += ":" + version
+		if latest.After(modTime) {
+			modTime = latest
+		}
+	}
+
+	return stat, modTime
+}
+
+// BytesSource returns a Source for a JSON document held in memory. It never changes.
+func BytesSource(data []byte) Source {
+	return bytesSource(data)
+}
+
+type bytesSource []byte
+
+func (b bytesSource) Read() ([]byte, error) {
+	return b, nil
+}
+
+func (b bytesSource) Version() (string, error) {
+	return "", nil
+}
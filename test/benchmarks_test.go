@@ -65,3 +65,43 @@ func BenchmarkLoadWithCaching(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkConfigCurrent(b *testing.B) {
+	config := transfig.NewConfig[complex]("complex.json", "test")
+
+	_, err := config.Load()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			complexData := config.Current()
+			if complexData.IntValue == 0 {
+				b.Fatal("config not loaded")
+			}
+		}
+	})
+}
+
+func BenchmarkConfigGet(b *testing.B) {
+	config := transfig.NewConfig[complex]("complex.json", "test")
+
+	_, err := config.Load()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			complexData := config.Get()
+			if complexData.IntValue == 0 {
+				b.Fatal("config not loaded")
+			}
+		}
+	})
+}
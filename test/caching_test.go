@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -97,8 +98,94 @@ func TestCachedVersionsAreCopies(t *testing.T) {
 	}
 
 	complex1.IntValue += 1
+	complex1.SliceValueInts[0] = 99
+	complex1.SliceValueInts = append(complex1.SliceValueInts[:1], 100)
+	complex1.SliceValueObjects[0].StringValue = "changed"
 
 	if complex1.IntValue == complex2.IntValue {
 		t.Errorf("complex1 value (%d) should be different to complex2 value (%d)", complex1.IntValue, complex2.IntValue)
 	}
+
+	if complex2.SliceValueInts[0] == 99 || complex2.SliceValueInts[1] == 100 {
+		t.Errorf("complex2 slice %v should not share complex1 slice %v", complex2.SliceValueInts, complex1.SliceValueInts)
+	}
+
+	if complex2.SliceValueObjects[0].StringValue == "changed" {
+		t.Errorf("complex2 slice of objects should not share complex1 slice of objects")
+	}
+
+	var complex3 complex
+	err = transfig.LoadWithCaching("complex.json", "test", &complex3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(complex2, complex3) {
+		t.Errorf("cached config was modified.\nExpected:\n%v\n\nActual:\n%v", complex2, complex3)
+	}
+}
+
+type referenceTypes struct {
+	Servers  []*server          `json:"servers"`
+	Primary  *server            `json:"primary"`
+	Ports    [2]int             `json:"ports"`
+	Limits   map[string][]int   `json:"limits"`
+	Backends map[string]*server `json:"backends"`
+	Extra    interface{}        `json:"extra"`
+	Nested   [][]string         `json:"nested"`
+	ByName   map[string]server  `json:"byName"`
+}
+
+type server struct {
+	Host string   `json:"host"`
+	Tags []string `json:"tags"`
+}
+
+func TestCachedVersionsAreDeepCopies(t *testing.T) {
+	// arrange
+	source := transfig.BytesSource([]byte(`{
+		"servers": [{ "host": "a", "tags": ["x"] }],
+		"primary": { "host": "b", "tags": ["y"] },
+		"ports": [80, 443],
+		"limits": { "cpu": [1, 2] },
+		"backends": { "c": { "host": "c" } },
+		"extra": { "list": [1, 2] },
+		"nested": [["d"]],
+		"byName": { "e": { "host": "e", "tags": ["z"] } }
+	}`))
+
+	var config1 referenceTypes
+	err := transfig.LoadSourcesWithCaching("TestCachedVersionsAreDeepCopies", &config1, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	config1.Servers[0].Host = "changed"
+	config1.Servers[0].Tags[0] = "changed"
+	config1.Primary.Host = "changed"
+	config1.Ports[0] = 0
+	config1.Limits["cpu"][0] = 0
+	config1.Limits["memory"] = []int{0}
+	config1.Backends["c"].Host = "changed"
+	config1.Extra.(map[string]interface{})["list"].([]interface{})[0] = "changed"
+	config1.Nested[0][0] = "changed"
+	config1.ByName["e"].Tags[0] = "changed"
+
+	var config2 referenceTypes
+	err = transfig.LoadSourcesWithCaching("TestCachedVersionsAreDeepCopies", &config2, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	var expected referenceTypes
+	err = transfig.LoadSources(&expected, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, config2) {
+		t.Errorf("cached config was modified.\nExpected:\n%+v\n\nActual:\n%+v", expected, config2)
+	}
 }
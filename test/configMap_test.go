@@ -0,0 +1,130 @@
+package transfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// writeConfigMap lays out files the way the kubelet mounts a ConfigMap: the files live in a
+// timestamped directory, "..data" links to it, and each key is a symlink through "..data".
+// Calling it again atomically swaps "..data" over to a new timestamped directory.
+func writeConfigMap(t *testing.T, dir, timestamp string, files map[string]string) {
+	dataDir := filepath.Join(dir, "..2024_01_01_"+timestamp)
+
+	err := os.Mkdir(dataDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(dataDir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// make sure the swap, not the modification time, is what gets noticed
+		err = os.Chtimes(filepath.Join(dataDir, name), time.Unix(0, 0), time.Unix(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			err = os.Symlink(filepath.Join("..data", name), link)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	err = os.Symlink(filepath.Base(dataDir), tmpLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(tmpLink, filepath.Join(dir, "..data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigMapSource(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	writeConfigMap(t, dir, "00_00_00", map[string]string{
+		"stringValue":                         "Hello world 2\n",
+		"intValue":                            "456",
+		"sliceValueStrings":                   `["string4", "string5"]`,
+		"objectValue.objectValue.stringValue": "Hello world 3",
+	})
+
+	err := os.MkdirAll(filepath.Join(dir, "objectValue"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "objectValue", "intValue"), []byte("789"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actualConfig complex
+
+	// act
+	err = transfig.LoadSources(&actualConfig, transfig.FileSource("complex.json"), transfig.ConfigMapSource(dir))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.StringValue = "Hello world 2"
+	expected.IntValue = 456
+	expected.SliceValueStrings = []string{"string4", "string5"}
+	expected.ObjectValue.IntValue = 789
+	expected.ObjectValue.ObjectValue.StringValue = "Hello world 3"
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestConfigMapSource_SymlinkSwap(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	dir := t.TempDir()
+	writeConfigMap(t, dir, "00_00_00", map[string]string{"intValue": "456"})
+
+	var config complex
+	err := transfig.LoadSourcesWithCaching(dir, &config, transfig.FileSource("complex.json"), transfig.ConfigMapSource(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	writeConfigMap(t, dir, "00_00_01", map[string]string{"intValue": "789"})
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	err = transfig.LoadSourcesWithCaching(dir, &config, transfig.FileSource("complex.json"), transfig.ConfigMapSource(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 789 {
+		t.Errorf("expected: %d but got %d", 789, config.IntValue)
+	}
+}
@@ -0,0 +1,290 @@
+package transfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestConfig_Load(t *testing.T) {
+	// arrange
+	config := transfig.NewConfig[complex]("complex.json", "dev")
+
+	// act
+	actualConfig, err := config.Load()
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, config.Current()) {
+		t.Errorf("Current should return the loaded config.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, config.Current())
+	}
+}
+
+func TestConfig_PrimaryFileNotExist(t *testing.T) {
+	// arrange
+	config := transfig.NewConfig[complex]("notExists.json", "dev")
+
+	// act
+	_, err := config.Load()
+
+	// assert
+	if err != transfig.ErrPrimaryConfigFileNotExist {
+		t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
+	}
+
+	if !reflect.DeepEqual(complex{}, config.Current()) {
+		t.Errorf("Current should return the zero value when the config can't be loaded, got:\n%v", config.Current())
+	}
+}
+
+func TestConfig_CurrentReloads(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	err := os.WriteFile("TestConfig_CurrentReloads.json", []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("TestConfig_CurrentReloads.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	config := transfig.NewConfig[superfluousFields]("TestConfig_CurrentReloads.json", "test")
+	preCacheConfig := config.Current()
+
+	// act
+	err = os.WriteFile("TestConfig_CurrentReloads.json", []byte(`{ "intValue": 2 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make sure the modification time changes, however coarse it is
+	future := time.Now().Add(time.Second)
+	err = os.Chtimes("TestConfig_CurrentReloads.json", future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(time.Duration(time.Millisecond * 200))
+	postCacheConfig := config.Current()
+
+	// assert
+	if preCacheConfig.IntValue != 1 {
+		t.Errorf("pre-caching: expected: %d but got %d", 1, preCacheConfig.IntValue)
+	}
+
+	if postCacheConfig.IntValue != 2 {
+		t.Errorf("post-caching: expected: %d but got %d", 2, postCacheConfig.IntValue)
+	}
+}
+
+func TestConfig_CurrentReturnsCopies(t *testing.T) {
+	// arrange
+	config := transfig.NewConfig[complex]("complex.json", "dev")
+
+	current1 := config.Current()
+
+	// act
+	current1.SliceValueObjects[0].StringValue = "changed"
+	current1.SliceValueInts[0] = 99
+
+	current2 := config.Current()
+
+	// assert
+	if !reflect.DeepEqual(expectedConfig, current2) {
+		t.Errorf("Current should return its own copy.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, current2)
+	}
+}
+
+func TestConfig_GetReloads(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	source := &memorySource{}
+	source.set(`{ "intValue": 1 }`)
+
+	config := transfig.NewConfigSources[superfluousFields](source).WithLoader(loader)
+
+	snapshot1 := config.Get()
+	if snapshot1 == nil {
+		t.Fatal("expected the config to be loaded")
+	}
+
+	// act
+	source.set(`{ "intValue": 2 }`)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	snapshot2 := config.Get()
+
+	source.set(`{ "intValue": `)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	snapshot3 := config.Get()
+
+	// assert
+	if snapshot1.IntValue != 1 {
+		t.Errorf("first snapshot: expected: %d but got %d", 1, snapshot1.IntValue)
+	}
+
+	if snapshot2.IntValue != 2 {
+		t.Errorf("reloaded snapshot: expected: %d but got %d", 2, snapshot2.IntValue)
+	}
+
+	if snapshot3 != snapshot2 {
+		t.Errorf("a failed reload should keep the last good snapshot, got %+v", snapshot3)
+	}
+}
+
+func TestConfig_GetNeverLoaded(t *testing.T) {
+	// arrange
+	config := transfig.NewConfig[complex]("notExists.json", "dev")
+
+	// act
+	snapshot := config.Get()
+
+	// assert
+	if snapshot != nil {
+		t.Errorf("expected nil but got %+v", snapshot)
+	}
+}
+
+func TestConfig_GetAfterPrimaryFileRecreated(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	defer loader.Close()
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+
+	path := filepath.Join(t.TempDir(), "superfluousFields.json")
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := transfig.NewConfig[superfluousFields](path, "test").WithLoader(loader)
+	if config.Get() == nil {
+		t.Fatal("expected the config to be loaded")
+	}
+
+	// act: the config is evicted once its primary file is deleted
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	deletedSnapshot := config.Get()
+
+	err = os.WriteFile(path, []byte(`{ "intValue": 2 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recreatedSnapshot := config.Get()
+
+	// assert
+	if deletedSnapshot == nil || deletedSnapshot.IntValue != 1 {
+		t.Errorf("while deleted: expected the last good config, with: %d but got %+v", 1, deletedSnapshot)
+	}
+
+	if recreatedSnapshot == nil || recreatedSnapshot.IntValue != 2 {
+		t.Errorf("once recreated: expected: %d but got %+v", 2, recreatedSnapshot)
+	}
+}
+
+// gatedSource is a memorySource whose reads wait once it's closed, until it's opened again
+type gatedSource struct {
+	memorySource
+	gateMu  sync.Mutex
+	gate    chan struct{}
+	reading chan struct{}
+}
+
+func (g *gatedSource) close() {
+	g.gateMu.Lock()
+	defer g.gateMu.Unlock()
+	g.gate = make(chan struct{})
+	g.reading = make(chan struct{})
+}
+
+func (g *gatedSource) open() {
+	g.gateMu.Lock()
+	defer g.gateMu.Unlock()
+	close(g.gate)
+	g.gate = nil
+}
+
+func (g *gatedSource) Read() ([]byte, error) {
+	g.gateMu.Lock()
+	gate, reading := g.gate, g.reading
+	g.gateMu.Unlock()
+
+	if gate != nil {
+		close(reading)
+		<-gate
+	}
+
+	return g.memorySource.Read()
+}
+
+func TestConfig_GetIgnoresDiscardedReloads(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	source := &gatedSource{}
+	source.set(`{ "intValue": 1 }`)
+
+	config := transfig.NewConfigSources[superfluousFields](source).WithLoader(loader)
+	if config.Get() == nil {
+		t.Fatal("expected the config to be loaded")
+	}
+
+	// a reload gets stuck reading the source
+	source.close()
+	reading := source.reading
+	source.set(`{ "intValue": 2 }`)
+	<-reading
+
+	// act: the Loader is closed while it's reloading, so the reload is discarded
+	closed := make(chan struct{})
+	go func() {
+		loader.Close()
+		close(closed)
+	}()
+
+	<-time.After(time.Duration(time.Millisecond * 100))
+	source.open()
+	<-closed
+
+	// the config is loaded afresh once the Loader is used again
+	source.set(`{ "intValue": 3 }`)
+
+	// assert
+	if snapshot := config.Get(); snapshot.IntValue != 3 {
+		t.Errorf("expected: %d but got %d", 3, snapshot.IntValue)
+	}
+}
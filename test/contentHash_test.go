@@ -0,0 +1,175 @@
+package transfig_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestFSSource_TouchKeepsVersion(t *testing.T) {
+	// arrange
+	modTime := time.Now().Add(-time.Hour)
+	fsys := fstest.MapFS{
+		"config.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime},
+	}
+	source := transfig.FSSource(fsys, "config.json")
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys["config.json"] = &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime.Add(time.Minute)}
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if version1 != version2 {
+		t.Errorf("expected touching the file to keep version %s but got %s", version1, version2)
+	}
+}
+
+func TestFSSource_OlderModTimeChangesVersion(t *testing.T) {
+	// arrange
+	modTime := time.Now().Add(-time.Hour)
+	fsys := fstest.MapFS{
+		"config.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime},
+	}
+	source := transfig.FSSource(fsys, "config.json")
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys["config.json"] = &fstest.MapFile{Data: []byte(`{ "intValue": 2 }`), ModTime: modTime.Add(-time.Hour)}
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if version1 == version2 {
+		t.Errorf("expected restoring an older file to change version %s", version1)
+	}
+}
+
+func TestFSSource_SameModTimeChangesVersion(t *testing.T) {
+	// arrange
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"config.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime},
+	}
+	source := transfig.FSSource(fsys, "config.json")
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys["config.json"] = &fstest.MapFile{Data: []byte(`{ "intValue": 2 }`), ModTime: modTime}
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if version1 == version2 {
+		t.Errorf("expected rewriting the file within the same modification time to change version %s", version1)
+	}
+}
+
+func TestFragmentsSource_TouchKeepsVersion(t *testing.T) {
+	// arrange
+	modTime := time.Now().Add(-time.Hour)
+	fsys := fstest.MapFS{
+		"config.d/10-a.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime},
+	}
+	source := transfig.FragmentsSource(fsys, "config.d")
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys["config.d/10-a.json"] = &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime.Add(time.Minute)}
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if version1 != version2 {
+		t.Errorf("expected touching a fragment to keep version %s but got %s", version1, version2)
+	}
+}
+
+func TestFragmentsSource_SameModTimeChangesVersion(t *testing.T) {
+	// arrange
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"config.d/10-a.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`), ModTime: modTime},
+	}
+	source := transfig.FragmentsSource(fsys, "config.d")
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys["config.d/10-a.json"] = &fstest.MapFile{Data: []byte(`{ "intValue": 2 }`), ModTime: modTime}
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if version1 == version2 {
+		t.Errorf("expected rewriting a fragment within the same modification time to change version %s", version1)
+	}
+}
+
+func TestLoader_TouchDoesNotReload(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	modTime := time.Now().Add(-time.Hour)
+	fsys := &lockedFS{files: fstest.MapFS{}}
+	fsys.set("superfluousFields.json", `{ "intValue": 1 }`, modTime)
+
+	var config superfluousFields
+	err := loader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan transfig.ReloadEvent, 10)
+	stop := loader.Notify(events)
+	defer stop()
+
+	// act
+	fsys.set("superfluousFields.json", `{ "intValue": 1 }`, modTime.Add(time.Minute))
+
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	// assert
+	if len(events) != 0 {
+		t.Errorf("expected: %d reloads but got %d", 0, len(events))
+	}
+}
@@ -0,0 +1,240 @@
+package transfig_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// hangingServer serves a JSON document, or once it's told to hang,
+// doesn't respond until the client gives up.
+type hangingServer struct {
+	mu      sync.Mutex
+	hanging bool
+}
+
+func (h *hangingServer) hang() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hanging = true
+}
+
+func (h *hangingServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	hanging := h.hanging
+	h.mu.Unlock()
+
+	if hanging {
+		<-req.Context().Done()
+		return
+	}
+
+	res.Write([]byte(`{ "intValue": 1 }`))
+}
+
+func TestLoadContext_Cancelled(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var config complex
+
+	// act
+	err := transfig.LoadContext(ctx, "complex.json", "dev", &config)
+
+	// assert
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err expected: \"%s\" but got: \"%v\"", context.Canceled, err)
+	}
+}
+
+func TestLoadSourcesContext_HTTPTimeout(t *testing.T) {
+	// arrange
+	handler := &hangingServer{}
+	handler.hang()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	defer server.CloseClientConnections()
+
+	source := &transfig.HTTPSource{
+		URL:    server.URL,
+		Client: server.Client(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Millisecond*100))
+	defer cancel()
+
+	var config superfluousFields
+
+	// act
+	start := time.Now()
+	err := transfig.LoadSourcesContext(ctx, &config, source)
+
+	// assert
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err expected: \"%s\" but got: \"%v\"", context.DeadlineExceeded, err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("expected the load to give up after the timeout, but it took %s", time.Since(start))
+	}
+}
+
+func TestLoader_CloseInterruptsPolling(t *testing.T) {
+	// arrange
+	handler := &hangingServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	defer server.CloseClientConnections()
+
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+
+	source := &transfig.HTTPSource{
+		URL:    server.URL,
+		Client: server.Client(),
+	}
+
+	var config superfluousFields
+	err := loader.LoadSourcesWithCaching("TestLoader_CloseInterruptsPolling", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// let the poller get stuck waiting on the server
+	handler.hang()
+	<-time.After(time.Duration(time.Millisecond * 200))
+
+	// act
+	closed := make(chan struct{})
+	go func() {
+		loader.Close()
+		close(closed)
+	}()
+
+	// assert
+	select {
+	case <-closed:
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for the loader to close")
+	}
+}
+
+func TestLoader_CloseStopsReloads(t *testing.T) {
+	// arrange
+	goroutines := runtime.NumGoroutine()
+
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+
+	source := &memorySource{}
+	source.set(`{ "intValue": 1 }`)
+
+	events := make(chan transfig.ReloadEvent, 1)
+	stop := loader.Notify(events)
+	defer stop()
+
+	var config superfluousFields
+	err := loader.LoadSourcesWithCaching("TestLoader_CloseStopsReloads", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	loader.Close()
+
+	// assert
+	if running := runtime.NumGoroutine(); running > goroutines {
+		t.Errorf("expected at most %d goroutines once closed, but got %d", goroutines, running)
+	}
+
+	source.set(`{ "intValue": 2 }`)
+	<-time.After(time.Duration(time.Millisecond * 200))
+
+	if len(events) != 0 {
+		t.Errorf("expected: %d reloads but got %d", 0, len(events))
+	}
+
+	// the loader starts again if it's used after being closed
+	err = loader.LoadSourcesWithCaching("TestLoader_CloseStopsReloads", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, config.IntValue)
+	}
+
+	loader.Close()
+}
+
+// delayedSource is a ContextSource that takes delay to read, unless its context is done first
+type delayedSource struct {
+	delay time.Duration
+}
+
+func (s *delayedSource) Read() ([]byte, error) {
+	return s.ReadContext(context.Background())
+}
+
+func (s *delayedSource) Version() (string, error) {
+	return "1", nil
+}
+
+func (s *delayedSource) ReadContext(ctx context.Context) ([]byte, error) {
+	select {
+	case <-time.After(s.delay):
+		return []byte(`{ "intValue": 1 }`), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *delayedSource) VersionContext(ctx context.Context) (string, error) {
+	return s.Version()
+}
+
+func TestConfig_LoadContext_WaiterOutlivesCaller(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	defer loader.Close()
+
+	config := transfig.NewConfigSources[superfluousFields](&delayedSource{delay: time.Duration(time.Millisecond * 200)}).
+		WithLoader(loader)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Millisecond*50))
+	defer cancel()
+
+	callerErr := make(chan error, 1)
+	go func() {
+		_, err := config.LoadContext(ctx)
+		callerErr <- err
+	}()
+
+	// let the first caller start loading
+	<-time.After(time.Duration(time.Millisecond * 10))
+
+	// act
+	settings, err := config.LoadContext(context.Background())
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.IntValue != 1 {
+		t.Errorf("expected: %d but got %d", 1, settings.IntValue)
+	}
+
+	if err := <-callerErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err expected: \"%s\" but got: \"%v\"", context.DeadlineExceeded, err)
+	}
+}
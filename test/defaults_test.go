@@ -0,0 +1,149 @@
+package transfig_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+type defaultedConfig struct {
+	Name     string            `json:"name" default:"app"`
+	Port     int               `json:"port" default:"8080"`
+	Ratio    float64           `json:"ratio" default:"0.5"`
+	Debug    bool              `json:"debug" default:"true"`
+	Timeout  time.Duration     `json:"timeout" default:"30s"`
+	Hosts    []string          `json:"hosts" default:"a.example.com, b.example.com"`
+	Ports    []int             `json:"ports" default:"[80, 443]"`
+	Labels   map[string]string `json:"labels" default:"{\"team\": \"core\"}"`
+	Address  net.IP            `json:"address" default:"127.0.0.1"`
+	Retries  *int              `json:"retries" default:"3"`
+	Database defaultedDatabase `json:"database"`
+}
+
+type defaultedDatabase struct {
+	Driver  string `json:"driver" default:"postgres"`
+	MaxOpen int    `json:"maxOpen" default:"10"`
+}
+
+func TestDefaults(t *testing.T) {
+	// arrange
+	data := []byte(`{ "port": 9090, "hosts": ["c.example.com"], "database": { "maxOpen": 20 } }`)
+	envData := []byte(`{ "timeout": "1m" }`)
+
+	var config defaultedConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retries := 3
+	expected := defaultedConfig{
+		Name:     "app",
+		Port:     9090,
+		Ratio:    0.5,
+		Debug:    true,
+		Timeout:  time.Duration(time.Minute),
+		Hosts:    []string{"c.example.com"},
+		Ports:    []int{80, 443},
+		Labels:   map[string]string{"team": "core"},
+		Address:  net.ParseIP("127.0.0.1"),
+		Retries:  &retries,
+		Database: defaultedDatabase{Driver: "postgres", MaxOpen: 20},
+	}
+
+	if !reflect.DeepEqual(expected, config) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%+v\n\nActual:\n%+v", expected, config)
+	}
+}
+
+func TestDefaults_CommaSeparatedSlice(t *testing.T) {
+	// arrange
+	var config defaultedConfig
+
+	// act
+	err := transfig.LoadBytes([]byte(`{}`), nil, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a.example.com", "b.example.com"}
+	if !reflect.DeepEqual(expected, config.Hosts) {
+		t.Errorf("expected hosts %v but got %v", expected, config.Hosts)
+	}
+}
+
+func TestDefaults_Invalid(t *testing.T) {
+	// arrange
+	type badConfig struct {
+		Timeout time.Duration `json:"timeout" default:"soon"`
+	}
+
+	var config badConfig
+
+	// act
+	err := transfig.LoadBytes([]byte(`{}`), nil, &config)
+
+	// assert
+	if err == nil || !strings.HasPrefix(err.Error(), "config: invalid default for transfig_test.badConfig.Timeout") {
+		t.Errorf("expected an invalid default error but got: %v", err)
+	}
+}
+
+func TestDefaults_MapSetByPrimary(t *testing.T) {
+	// arrange
+	data := []byte(`{ "labels": { "env": "live" } }`)
+
+	var config defaultedConfig
+
+	// act
+	err := transfig.LoadBytes(data, nil, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"env": "live"}
+	if !reflect.DeepEqual(expected, config.Labels) {
+		t.Errorf("expected labels %v but got %v", expected, config.Labels)
+	}
+}
+
+func TestDefaults_NestedPointer(t *testing.T) {
+	// arrange
+	type pointerConfig struct {
+		Database *defaultedDatabase `json:"database"`
+		Replica  *defaultedDatabase `json:"replica"`
+	}
+
+	data := []byte(`{ "database": { "maxOpen": 20 } }`)
+
+	var config pointerConfig
+
+	// act
+	err := transfig.LoadBytes(data, nil, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := defaultedDatabase{Driver: "postgres", MaxOpen: 20}
+	if config.Database == nil || *config.Database != expected {
+		t.Errorf("expected database %+v but got %+v", expected, config.Database)
+	}
+
+	if config.Replica != nil {
+		t.Errorf("expected a struct the primary config doesn't set to be left nil, but got %+v", config.Replica)
+	}
+}
@@ -0,0 +1,142 @@
+package transfig_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestWatchLoaderField(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer loader.Close()
+
+	err := copyFile("_TestWatchLoaderField.json", "complex.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("_TestWatchLoaderField.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var config complex
+	err = loader.LoadWithCaching("_TestWatchLoaderField.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafValues := make(chan string, 1)
+	defer transfig.WatchLoaderField(loader, "objectValue.objectValue.stringValue", func(newValue string) {
+		leafValues <- newValue
+	})()
+
+	subtreeValues := make(chan subConfiguration, 1)
+	defer transfig.WatchLoaderField(loader, "objectValue", func(newValue subConfiguration) {
+		subtreeValues <- newValue
+	})()
+
+	convertedValues := make(chan int64, 1)
+	defer transfig.WatchLoaderField(loader, "sliceValueObjects[2].intValue", func(newValue int64) {
+		convertedValues <- newValue
+	})()
+
+	unchangedValues := make(chan float64, 1)
+	defer transfig.WatchLoaderField(loader, "floatValue", func(newValue float64) {
+		unchangedValues <- newValue
+	})()
+
+	// act
+	var changedConfig complex
+	err = transfig.Load("complex.json", "test", &changedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changedConfig.ObjectValue.ObjectValue.StringValue = "Hello world 2"
+	changedConfig.SliceValueObjects[2].IntValue = 456
+	writeChangedFile(t, "_TestWatchLoaderField.json", mustMarshal(t, changedConfig))
+
+	// assert
+	select {
+	case newValue := <-leafValues:
+		if newValue != "Hello world 2" {
+			t.Errorf("leaf: expected '%s', actual '%s'", "Hello world 2", newValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for leaf watcher")
+	}
+
+	select {
+	case newValue := <-subtreeValues:
+		if newValue.ObjectValue.StringValue != "Hello world 2" {
+			t.Errorf("subtree: expected '%s', actual '%s'", "Hello world 2", newValue.ObjectValue.StringValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for subtree watcher")
+	}
+
+	select {
+	case newValue := <-convertedValues:
+		if newValue != 456 {
+			t.Errorf("converted: expected %d, actual %d", 456, newValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for converted watcher")
+	}
+
+	select {
+	case newValue := <-unchangedValues:
+		t.Errorf("watcher for an unchanged field should not be called, but got %f", newValue)
+	default:
+	}
+}
+
+func TestWatchConfigField(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer loader.Close()
+
+	err := os.WriteFile("TestWatchConfigField.json", []byte(`{ "stringValue": "debug", "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("TestWatchConfigField.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	config := transfig.NewConfig[superfluousFields]("TestWatchConfigField.json", "test").WithLoader(loader)
+	_, err = config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels := make(chan string, 2)
+	defer transfig.WatchConfigField(config, "stringValue", func(level string) {
+		levels <- level
+	})()
+
+	// act
+	writeChangedFile(t, "TestWatchConfigField.json", `{ "stringValue": "warn", "intValue": 1 }`)
+
+	// assert
+	select {
+	case level := <-levels:
+		if level != "warn" {
+			t.Errorf("expected '%s', actual '%s'", "warn", level)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for field watcher")
+	}
+}
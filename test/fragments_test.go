@@ -0,0 +1,117 @@
+package transfig_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestLoad_Fragments(t *testing.T) {
+	// arrange
+	var actualConfig complex
+
+	// act
+	err := transfig.Load("complex.d", "dev", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+}
+
+func TestLoad_FragmentsWithEnvironment(t *testing.T) {
+	// arrange
+	var actualConfig complex
+
+	err := os.MkdirAll("complex.d/test", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.RemoveAll("complex.d/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = os.WriteFile("complex.d/test/10-db.json", []byte(`{ "intValue": 456, "objectValue": { "intValue": 456 } }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile("complex.d/test/20-email.json", []byte(`{ "intValue": 789, "sliceValueInts": [ 4 ] }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = transfig.Load("complex.d", "test", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.IntValue = 789
+	expected.SliceValueInts = []int{4}
+	expected.ObjectValue.IntValue = 456
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestLoadWithCaching_NewFragment(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	err := os.MkdirAll("complex.d/live", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.RemoveAll("complex.d/live")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var config complex
+	err = transfig.LoadWithCaching("complex.d", "live", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = os.WriteFile("complex.d/live/10-db.json", []byte(`{ "intValue": 456 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	err = transfig.LoadWithCaching("complex.d", "live", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 456 {
+		t.Errorf("expected: %d but got %d", 456, config.IntValue)
+	}
+}
@@ -0,0 +1,182 @@
+package transfig_test
+
+import (
+	"embed"
+	"io/fs"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+//go:embed complex.json withComments.json
+var embeddedConfigs embed.FS
+
+// lockedFS guards a MapFS so a test can modify it while the reload poller reads it.
+type lockedFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+func (l *lockedFS) Open(name string) (fs.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.files.Open(name)
+}
+
+func (l *lockedFS) set(name, data string, modTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[name] = &fstest.MapFile{Data: []byte(data), ModTime: modTime}
+}
+
+func (l *lockedFS) remove(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.files, name)
+}
+
+func TestLoadFS_Embedded(t *testing.T) {
+	// arrange
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadFS(embeddedConfigs, "withComments.json", "test", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+}
+
+func TestLoadFS_WithEnvironment(t *testing.T) {
+	// arrange
+	fsys := fstest.MapFS{
+		"config/superfluousFields.json": &fstest.MapFile{
+			Data: []byte(`{ "stringValue": "Hello world", "intValue": 123 }`),
+		},
+		"config/superfluousFields.test.json": &fstest.MapFile{
+			Data: []byte(`{ "stringValue": "Hello world 2" }`),
+		},
+	}
+
+	var actualConfig superfluousFields
+
+	// act
+	err := transfig.LoadFS(fsys, "config/superfluousFields.json", "test", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := superfluousFields{
+		StringValue: "Hello world 2",
+		IntValue:    123,
+	}
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestLoadFS_PrimaryFileNotExist(t *testing.T) {
+	// arrange
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadFS(fstest.MapFS{}, "complex.json", "test", &actualConfig)
+
+	// assert
+	if err != transfig.ErrPrimaryConfigFileNotExist {
+		t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
+	}
+}
+
+func TestLoadWithCachingFS(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	modTime := time.Now()
+	fsys := &lockedFS{files: fstest.MapFS{}}
+	fsys.set("superfluousFields.json", `{ "intValue": 1 }`, modTime)
+
+	var config superfluousFields
+	err := transfig.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys.set("superfluousFields.json", `{ "intValue": 2 }`, modTime.Add(time.Second))
+
+	var preCacheConfig superfluousFields
+	err = transfig.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &preCacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	var postCacheConfig superfluousFields
+	err = transfig.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &postCacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if preCacheConfig.IntValue != 1 {
+		t.Errorf("pre-caching: expected: %d but got %d", 1, preCacheConfig.IntValue)
+	}
+
+	if postCacheConfig.IntValue != 2 {
+		t.Errorf("post-caching: expected: %d but got %d", 2, postCacheConfig.IntValue)
+	}
+}
+
+// wrappedFS is a struct file system holding a map, so it can't be hashed
+type wrappedFS struct {
+	fs.FS
+}
+
+func TestLoadWithCachingFS_NotCacheable(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	defer loader.Close()
+
+	fsys := wrappedFS{fstest.MapFS{
+		"superfluousFields.json": &fstest.MapFile{Data: []byte(`{ "intValue": 1 }`)},
+	}}
+
+	// act
+	var config superfluousFields
+	err := loader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+
+	var pointerConfig superfluousFields
+	pointerErr := loader.LoadWithCachingFS(&fsys, "superfluousFields.json", "test", &pointerConfig)
+
+	// assert
+	if err != transfig.ErrFSNotCacheable {
+		t.Errorf("err expected: \"%s\" but got: \"%v\"", transfig.ErrFSNotCacheable, err)
+	}
+
+	if pointerErr != nil {
+		t.Fatal(pointerErr)
+	}
+
+	if pointerConfig.IntValue != 1 {
+		t.Errorf("expected: %d but got %d", 1, pointerConfig.IntValue)
+	}
+}
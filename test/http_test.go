@@ -0,0 +1,187 @@
+package transfig_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sironfoot/transfig"
+)
+
+// configServer serves a JSON document with an ETag, counting full downloads.
+type configServer struct {
+	mu        sync.Mutex
+	data      string
+	etag      string
+	downloads int
+}
+
+func (c *configServer) set(data, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = data
+	c.etag = etag
+}
+
+func (c *configServer) downloadCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.downloads
+}
+
+func (c *configServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if req.Header.Get("If-None-Match") == c.etag {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	c.downloads++
+	res.Header().Set("ETag", c.etag)
+	res.Write([]byte(c.data))
+}
+
+func writeCAFile(t *testing.T, server *httptest.Server) string {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	err := os.WriteFile(caFile, caPEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return caFile
+}
+
+func TestHTTPSource_ETag(t *testing.T) {
+	// arrange
+	handler := &configServer{}
+	handler.set(`{ "intValue": 456 }`, `"v1"`)
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	source := &transfig.HTTPSource{
+		URL:         server.URL,
+		CAFile:      writeCAFile(t, server),
+		BearerToken: "secret",
+	}
+
+	var config complex
+	err := transfig.LoadSources(&config, transfig.FileSource("complex.json"), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version1, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	handler.set(`{ "intValue": 789 }`, `"v2"`)
+
+	version2, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version3, err := source.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 456 {
+		t.Errorf("expected: %d but got %d", 456, config.IntValue)
+	}
+
+	if version1 == version2 {
+		t.Errorf("version should change when the document does, got %s both times", version1)
+	}
+
+	if version2 != version3 {
+		t.Errorf("version should not change when the document hasn't: %s then %s", version2, version3)
+	}
+
+	if handler.downloadCount() != 2 {
+		t.Errorf("expected the document to be downloaded %d times, but was %d times", 2, handler.downloadCount())
+	}
+}
+
+func TestHTTPSource_Unauthorized(t *testing.T) {
+	// arrange
+	handler := &configServer{}
+	handler.set(`{ "intValue": 456 }`, `"v1"`)
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	source := &transfig.HTTPSource{
+		URL:    server.URL,
+		CAFile: writeCAFile(t, server),
+	}
+
+	// act
+	_, err := source.Read()
+
+	// assert
+	if err == nil {
+		t.Error("expected an error when the server refuses the request")
+	}
+}
+
+func TestHTTPSource_FallbackToCacheFile(t *testing.T) {
+	// arrange
+	handler := &configServer{}
+	handler.set(`{ "intValue": 456 }`, `"v1"`)
+
+	server := httptest.NewTLSServer(handler)
+	caFile := writeCAFile(t, server)
+	cacheFile := filepath.Join(t.TempDir(), "config.cache.json")
+
+	source := &transfig.HTTPSource{
+		URL:         server.URL,
+		CAFile:      caFile,
+		BearerToken: "secret",
+		CacheFile:   cacheFile,
+	}
+
+	_, err := source.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+
+	// act
+	restarted := &transfig.HTTPSource{
+		URL:         server.URL,
+		CAFile:      caFile,
+		BearerToken: "secret",
+		CacheFile:   cacheFile,
+	}
+
+	var config complex
+	err = transfig.LoadSources(&config, transfig.FileSource("complex.json"), restarted)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.IntValue != 456 {
+		t.Errorf("expected: %d but got %d", 456, config.IntValue)
+	}
+}
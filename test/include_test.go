@@ -0,0 +1,143 @@
+package transfig_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestLoadFS_Include(t *testing.T) {
+	// arrange
+	fsys := fstest.MapFS{
+		"config/complex.json": &fstest.MapFile{Data: []byte(`
+        {
+            "$include": "shared/values.json",
+            "stringValue": "Hello world",
+
+            "objectValue": {
+                // keys alongside an include are applied over the top of it
+                "$include": "shared/object.json",
+                "intValue": 123
+            },
+
+            "sliceValueObjects": [
+                { "$include": "shared/sliced.json" },
+                { "$include": "shared/sliced.json" },
+                { "$include": "shared/sliced.json" }
+            ]
+        }`)},
+		"config/shared/values.json": &fstest.MapFile{Data: []byte(`
+        {
+            "stringValue": "Overridden",
+            "intValue": 123,
+            "floatValue": 123.45,
+            "boolValue": true,
+            "$include": "slices/*.json"
+        }`)},
+		"config/shared/slices/1-strings.json": &fstest.MapFile{Data: []byte(`{ "sliceValueStrings": [ "string1", "string2", "string3" ] }`)},
+		"config/shared/slices/2-numbers.json": &fstest.MapFile{Data: []byte(`{ "sliceValueFloats": [ 1.2, 2.3, 3.4 ], "sliceValueInts": [ 1, 2, 3 ] }`)},
+		"config/shared/slices/3-bools.json":   &fstest.MapFile{Data: []byte(`{ "sliceValueBools": [ true, false, true ] }`)},
+		"config/shared/object.json": &fstest.MapFile{Data: []byte(`
+        {
+            "stringValue": "Hello world",
+            "intValue": 456,
+            "objectValue": { "$include": "../shared/sliced.json" }
+        }`)},
+		"config/shared/sliced.json": &fstest.MapFile{Data: []byte(`{ "stringValue": "Hello world", "intValue": 123 }`)},
+	}
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadFS(fsys, "config/complex.json", "test", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+}
+
+func TestLoadFS_IncludeCycle(t *testing.T) {
+	// arrange
+	fsys := fstest.MapFS{
+		"complex.json": &fstest.MapFile{Data: []byte(`{ "$include": "a.json" }`)},
+		"a.json":       &fstest.MapFile{Data: []byte(`{ "objectValue": { "$include": "b.json" } }`)},
+		"b.json":       &fstest.MapFile{Data: []byte(`{ "objectValue": { "$include": "a.json" } }`)},
+	}
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadFS(fsys, "complex.json", "test", &actualConfig)
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), "complex.json -> a.json -> b.json -> a.json") {
+		t.Errorf("expected an include cycle error, got: %v", err)
+	}
+}
+
+func TestLoadFS_IncludeNotExist(t *testing.T) {
+	// arrange
+	fsys := fstest.MapFS{
+		"complex.json": &fstest.MapFile{Data: []byte(`{ "$include": "missing.json" }`)},
+	}
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadFS(fsys, "complex.json", "test", &actualConfig)
+
+	// assert
+	if err == nil || err == transfig.ErrPrimaryConfigFileNotExist {
+		t.Errorf("expected an error about the missing include, got: %v", err)
+	}
+}
+
+func TestLoadWithCachingFS_IncludeChanged(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	modTime := time.Now()
+	fsys := &lockedFS{files: fstest.MapFS{}}
+	fsys.set("superfluousFields.json", `{ "$include": "shared.json" }`, modTime)
+	fsys.set("superfluousFields.test.json", `{ "$include": "shared/*.json" }`, modTime)
+	fsys.set("shared.json", `{ "intValue": 1 }`, modTime)
+
+	var config superfluousFields
+	err := transfig.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys.set("shared.json", `{ "intValue": 2 }`, modTime.Add(time.Second))
+	fsys.set("shared/env.json", `{ "stringValue": "Hello world 2" }`, modTime)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	err = transfig.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, config.IntValue)
+	}
+
+	if config.StringValue != "Hello world 2" {
+		t.Errorf("expected: %s but got %s", "Hello world 2", config.StringValue)
+	}
+}
@@ -0,0 +1,351 @@
+package transfig_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+type schemaCheckedConfig struct {
+	Name    string                `json:"name"`
+	Level   string                `json:"level"`
+	Port    int                   `json:"port"`
+	Secret  string                `json:"secret"`
+	Servers []schemaCheckedServer `json:"servers"`
+}
+
+type schemaCheckedServer struct {
+	Host string `json:"host"`
+}
+
+const checkedSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name", "secret"],
+	"additionalProperties": false,
+	"properties": {
+		"name": { "type": "string", "minLength": 1 },
+		"level": { "enum": ["debug", "info"] },
+		"port": { "type": "integer", "minimum": 1, "exclusiveMaximum": 65536 },
+		"secret": { "type": "string", "pattern": "^[a-z]+$" },
+		"servers": { "type": "array", "maxItems": 2, "items": { "$ref": "#/$defs/server" } }
+	},
+	"$defs": {
+		"server": {
+			"type": "object",
+			"required": ["host"],
+			"properties": { "host": { "type": "string" } }
+		}
+	}
+}`
+
+func newSchemaLoader(t *testing.T) *transfig.Loader {
+	schema, err := transfig.ParseSchema([]byte(checkedSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &transfig.Loader{Schema: schema}
+}
+
+func TestLoader_Schema(t *testing.T) {
+	// arrange
+	loader := newSchemaLoader(t)
+	data := []byte(`{ "name": "app", "level": "info", "port": 8080, "servers": [{ "host": "a" }] }`)
+	envData := []byte(`{ "secret": "abc" }`)
+
+	var config schemaCheckedConfig
+
+	// act
+	err := loader.LoadBytes(data, envData, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Secret != "abc" {
+		t.Errorf("expected: %s but got %s", "abc", config.Secret)
+	}
+}
+
+func TestLoader_SchemaPrimaryViolations(t *testing.T) {
+	// arrange
+	loader := newSchemaLoader(t)
+	data := []byte(`{
+		"name": "",
+		"level": "verbose",
+		"port": 65536,
+		"servers": [{ "host": 1 }, {}, {}],
+		"colour": "blue"
+	}`)
+
+	var config schemaCheckedConfig
+
+	// act
+	err := loader.LoadBytes(data, nil, &config)
+
+	// assert
+	var schemaErr *transfig.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a *transfig.SchemaError but got: %v", err)
+	}
+
+	if schemaErr.Document != "primary config" {
+		t.Errorf("expected: %s but got %s", "primary config", schemaErr.Document)
+	}
+
+	// required isn't checked until the documents are merged
+	expected := []transfig.Violation{
+		{Path: "colour", Rule: "additionalProperties", Message: "is not allowed"},
+		{Path: "level", Rule: "enum", Message: `must be one of "debug", "info", not "verbose"`},
+		{Path: "name", Rule: "minLength", Message: "must have a length of at least 1"},
+		{Path: "port", Rule: "exclusiveMaximum", Message: "must be less than 65536"},
+		{Path: "servers", Rule: "maxItems", Message: "must have at most 2 items"},
+		{Path: "servers[0].host", Rule: "type", Message: "must be of type string, not integer"},
+	}
+
+	if !reflect.DeepEqual(expected, schemaErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, schemaErr.Violations)
+	}
+
+	if !strings.HasPrefix(err.Error(), "config: primary config doesn't match schema: colour is not allowed; level must be") {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
+
+func TestLoader_SchemaEnvironmentViolations(t *testing.T) {
+	// arrange
+	loader := newSchemaLoader(t)
+	data := []byte(`{ "name": "app" }`)
+	envData := []byte(`{ "secret": "ABC" }`)
+
+	var config schemaCheckedConfig
+
+	// act
+	err := loader.LoadBytes(data, envData, &config)
+
+	// assert
+	var schemaErr *transfig.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a *transfig.SchemaError but got: %v", err)
+	}
+
+	expected := &transfig.SchemaError{
+		Document: "environment config",
+		Violations: []transfig.Violation{
+			{Path: "secret", Rule: "pattern", Message: "must match ^[a-z]+$"},
+		},
+	}
+
+	if !reflect.DeepEqual(expected, schemaErr) {
+		t.Errorf("expected: %v but got %v", expected, schemaErr)
+	}
+
+	if config.Name != "" {
+		t.Errorf("expected the config not to be decoded, but got: %+v", config)
+	}
+}
+
+func TestLoader_SchemaMergedViolations(t *testing.T) {
+	// arrange
+	loader := newSchemaLoader(t)
+	data := []byte(`{ "name": "app", "servers": [{}] }`)
+	envData := []byte(`{ "port": 80 }`)
+
+	var config schemaCheckedConfig
+
+	// act
+	err := loader.LoadBytes(data, envData, &config)
+
+	// assert
+	var schemaErr *transfig.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a *transfig.SchemaError but got: %v", err)
+	}
+
+	expected := &transfig.SchemaError{
+		Document: "merged config",
+		Violations: []transfig.Violation{
+			{Path: "secret", Rule: "required", Message: "is required"},
+			{Path: "servers[0].host", Rule: "required", Message: "is required"},
+		},
+	}
+
+	if !reflect.DeepEqual(expected, schemaErr) {
+		t.Errorf("expected: %v but got %v", expected, schemaErr)
+	}
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	schemas := []string{
+		`{ "type": 1 }`,
+		`{ "pattern": "(" }`,
+		`{ "properties": { "a": { "$ref": "#/$defs/missing" } } }`,
+		`{ "$defs": { "a": { "$ref": "#/$defs/a" } }, "properties": { "name": { "$ref": "#/$defs/a" } } }`,
+		`{ "$defs": { "a": { "$ref": "#/$defs/b" }, "b": { "$ref": "#/$defs/a" } } }`,
+		`{ "$ref": "#" }`,
+	}
+
+	for _, schema := range schemas {
+		// act
+		_, err := transfig.ParseSchema([]byte(schema))
+
+		// assert
+		if err == nil {
+			t.Errorf("expected an error parsing schema: %s", schema)
+		}
+	}
+}
+
+func TestParseSchema_RecursiveThroughProperties(t *testing.T) {
+	// arrange
+	schema, err := transfig.ParseSchema([]byte(`{
+		"$defs": { "object": { "properties": { "intValue": { "type": "integer" }, "objectValue": { "$ref": "#/$defs/object" } } } },
+		"$ref": "#/$defs/object"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &transfig.Loader{Schema: schema}
+
+	var config complex
+
+	// act
+	err = loader.LoadBytes([]byte(`{ "objectValue": { "objectValue": { "intValue": "a" } } }`), nil, &config)
+
+	// assert
+	var schemaErr *transfig.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a *transfig.SchemaError but got: %v", err)
+	}
+
+	if len(schemaErr.Violations) != 1 || schemaErr.Violations[0].Path != "objectValue.objectValue.intValue" {
+		t.Errorf("expected a violation at %s but got: %v", "objectValue.objectValue.intValue", schemaErr.Violations)
+	}
+}
+
+func TestParseSchema_Generated(t *testing.T) {
+	// arrange
+	data, err := transfig.Schema(&schemaConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := transfig.ParseSchema(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &transfig.Loader{Schema: schema}
+
+	var config schemaConfig
+
+	// act
+	err = loader.LoadBytes([]byte(`{ "level": "debug", "port": 80 }`), nil, &config)
+
+	// assert
+	expected := "config: merged config doesn't match schema: name is required"
+	if err == nil || err.Error() != expected {
+		t.Errorf("err expected: \"%s\" but got: \"%v\"", expected, err)
+	}
+}
+
+func newGeneratedSchemaLoader(t *testing.T) *transfig.Loader {
+	data, err := transfig.Schema(&schemaConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := transfig.ParseSchema(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &transfig.Loader{Schema: schema}
+}
+
+func TestLoader_SchemaWithKVSource(t *testing.T) {
+	// arrange
+	consul := newFakeConsul()
+	consul.put("myapp/port", "9090")
+	consul.put("myapp/timeout", "1m")
+	consul.put("myapp/debug", "true")
+	consul.put("myapp/hosts", `["a", "b"]`)
+
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	loader := newGeneratedSchemaLoader(t)
+	primary := &memorySource{data: []byte(`{ "name": "app" }`)}
+	source := transfig.KVSource(&transfig.ConsulKV{Address: server.URL}, "myapp/")
+
+	var config schemaConfig
+
+	// act
+	err := loader.LoadSources(&config, primary, source)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Port != 9090 {
+		t.Errorf("expected: %d but got %d", 9090, config.Port)
+	}
+	if config.Timeout != time.Minute {
+		t.Errorf("expected: %s but got %s", time.Minute, config.Timeout)
+	}
+	if !config.Debug {
+		t.Errorf("expected: %t but got %t", true, config.Debug)
+	}
+	if !reflect.DeepEqual([]string{"a", "b"}, config.Hosts) {
+		t.Errorf("expected: %v but got %v", []string{"a", "b"}, config.Hosts)
+	}
+}
+
+func TestLoader_SchemaWithConfigMapSource(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	for name, value := range map[string]string{"port": "70000\n", "debug": "yes\n"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := newGeneratedSchemaLoader(t)
+	primary := &memorySource{data: []byte(`{ "name": "app" }`)}
+
+	var config schemaConfig
+
+	// act
+	err := loader.LoadSources(&config, primary, transfig.ConfigMapSource(dir))
+
+	// assert
+	var schemaErr *transfig.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected a *transfig.SchemaError but got: %v", err)
+	}
+
+	// strings are checked as the type they're converted to, and left as strings if they can't be
+	expected := &transfig.SchemaError{
+		Document: "environment config",
+		Violations: []transfig.Violation{
+			{Path: "debug", Rule: "type", Message: "must be of type boolean, not string"},
+			{Path: "port", Rule: "maximum", Message: "must be at most 65535"},
+		},
+	}
+
+	if !reflect.DeepEqual(expected, schemaErr) {
+		t.Errorf("expected: %v but got %v", expected, schemaErr)
+	}
+}
@@ -0,0 +1,363 @@
+package transfig_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// fakeConsul is a tiny in-process imitation of Consul's KV HTTP API, including blocking queries.
+type fakeConsul struct {
+	mu      sync.Mutex
+	keys    map[string]string
+	index   uint64
+	changed chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		keys:    map[string]string{},
+		index:   1,
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeConsul) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keys[key] = value
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	prefix := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+	index, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+
+	f.mu.Lock()
+	if index != 0 && index == f.index {
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(time.Second * 5)):
+		case <-req.Context().Done():
+			return
+		}
+
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+
+	type pair struct {
+		Key   string
+		Value []byte
+	}
+
+	var pairs []pair
+	for key, value := range f.keys {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, pair{key, []byte(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	res.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if len(pairs) == 0 {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(res).Encode(pairs)
+}
+
+// fakeEtcd imitates the range and watch endpoints of etcd's v3 JSON gateway. Watches
+// never see any events, but can be cancelled as if their revision was compacted.
+type fakeEtcd struct {
+	mu        sync.Mutex
+	keys      map[string]string
+	compact   chan struct{}
+	compacted bool
+}
+
+// compactWith sets key to value, and cancels the open watch, or the next one if none
+// is open, because its revision has been compacted, so the change can't be watched.
+func (f *fakeEtcd) compactWith(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[key] = value
+	if f.compact != nil {
+		close(f.compact)
+		f.compact = nil
+	} else {
+		f.compacted = true
+	}
+}
+
+func (f *fakeEtcd) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/v3/watch" {
+		f.watch(res, req)
+		return
+	}
+
+	var rangeRequest struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}
+	json.NewDecoder(req.Body).Decode(&rangeRequest)
+
+	type keyValue struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+
+	f.mu.Lock()
+	var kvs []keyValue
+	for key, value := range f.keys {
+		if key >= string(rangeRequest.Key) && key < string(rangeRequest.RangeEnd) {
+			kvs = append(kvs, keyValue{[]byte(key), []byte(value)})
+		}
+	}
+	f.mu.Unlock()
+
+	json.NewEncoder(res).Encode(map[string]interface{}{
+		"header": map[string]string{"revision": "42"},
+		"kvs":    kvs,
+	})
+}
+
+func (f *fakeEtcd) watch(res http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	compact := make(chan struct{})
+	if f.compacted {
+		close(compact)
+		f.compacted = false
+	} else {
+		f.compact = compact
+	}
+	f.mu.Unlock()
+
+	encoder := json.NewEncoder(res)
+	encoder.Encode(map[string]interface{}{
+		"result": map[string]interface{}{"header": map[string]string{"revision": "42"}, "created": true},
+	})
+	res.(http.Flusher).Flush()
+
+	select {
+	case <-compact:
+		encoder.Encode(map[string]interface{}{
+			"result": map[string]interface{}{
+				"header":           map[string]string{"revision": "50"},
+				"canceled":         true,
+				"compact_revision": "45",
+			},
+		})
+	case <-req.Context().Done():
+	}
+}
+
+func TestKVSource_Consul(t *testing.T) {
+	// arrange
+	consul := newFakeConsul()
+	consul.put("myapp/stringValue", "Hello world 2")
+	consul.put("myapp/intValue", "456")
+	consul.put("myapp/boolValue", "false")
+	consul.put("myapp/sliceValueInts", "[4, 5]")
+	consul.put("myapp/objectValue/objectValue/stringValue", "Hello world 3")
+	consul.put("otherapp/intValue", "789")
+
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	source := transfig.KVSource(&transfig.ConsulKV{Address: server.URL}, "myapp/")
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadSources(&actualConfig, transfig.FileSource("complex.json"), source)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.StringValue = "Hello world 2"
+	expected.IntValue = 456
+	expected.BoolValue = false
+	expected.SliceValueInts = []int{4, 5}
+	expected.ObjectValue.ObjectValue.StringValue = "Hello world 3"
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestKVSource_ConsulBlockingQuery(t *testing.T) {
+	// arrange
+	consul := newFakeConsul()
+	consul.put("TestKVSource_ConsulBlockingQuery/intValue", "456")
+
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	// the cached config keeps a blocking query open, which would hold up Close
+	defer server.CloseClientConnections()
+
+	source := transfig.KVSource(&transfig.ConsulKV{Address: server.URL}, "TestKVSource_ConsulBlockingQuery/")
+
+	var config complex
+	err := transfig.LoadSourcesWithCaching("TestKVSource_ConsulBlockingQuery", &config,
+		transfig.FileSource("complex.json"), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	consul.put("TestKVSource_ConsulBlockingQuery/intValue", "789")
+
+	// the blocking query returns straight away, so there's no need to wait a polling interval
+	deadline := time.Now().Add(time.Duration(time.Second * 2))
+	for config.IntValue != 789 && time.Now().Before(deadline) {
+		<-time.After(time.Duration(time.Millisecond * 10))
+
+		err = transfig.LoadSourcesWithCaching("TestKVSource_ConsulBlockingQuery", &config,
+			transfig.FileSource("complex.json"), source)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// assert
+	if config.IntValue != 789 {
+		t.Errorf("expected: %d but got %d", 789, config.IntValue)
+	}
+}
+
+func TestKVSource_Etcd(t *testing.T) {
+	// arrange
+	etcd := &fakeEtcd{keys: map[string]string{
+		"/myapp/objectValue/intValue": "456",
+		"/myapp/floatValue":           "456.78",
+		"/myapp0/intValue":            "789",
+	}}
+
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	source := transfig.KVSource(&transfig.EtcdKV{Address: server.URL}, "/myapp/")
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadSources(&actualConfig, transfig.FileSource("complex.json"), source)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.FloatValue = 456.78
+	expected.ObjectValue.IntValue = 456
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestKVSource_MapField(t *testing.T) {
+	// arrange
+	type labelledConfig struct {
+		Labels map[string]string `json:"labels"`
+		Limits map[string]int    `json:"limits"`
+	}
+
+	consul := newFakeConsul()
+	consul.put("myapp/labels/b", "2")
+	consul.put("myapp/limits/requests", "100")
+
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	source := transfig.KVSource(&transfig.ConsulKV{Address: server.URL}, "myapp/")
+
+	var actualConfig labelledConfig
+
+	// act
+	err := transfig.LoadSources(&actualConfig,
+		transfig.BytesSource([]byte(`{ "labels": { "a": "1" } }`)),
+		source,
+	)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := labelledConfig{
+		Labels: map[string]string{"a": "1", "b": "2"},
+		Limits: map[string]int{"requests": 100},
+	}
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestKVSource_EtcdCompacted(t *testing.T) {
+	// arrange
+	etcd := &fakeEtcd{keys: map[string]string{
+		"/myapp/intValue": "456",
+	}}
+
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	// the cached config keeps a watch open, which would hold up Close
+	defer server.CloseClientConnections()
+
+	loader := &transfig.Loader{}
+	defer loader.Close()
+	loader.SetReloadPollingInterval(time.Duration(time.Hour))
+
+	source := transfig.KVSource(&transfig.EtcdKV{Address: server.URL}, "/myapp/")
+
+	primary := transfig.BytesSource([]byte(`{ "intValue": 1 }`))
+
+	var config superfluousFields
+	err := loader.LoadSourcesWithCaching("TestKVSource_EtcdCompacted", &config, primary, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	etcd.compactWith("/myapp/intValue", "789")
+
+	deadline := time.Now().Add(time.Duration(time.Second * 2))
+	for config.IntValue != 789 && time.Now().Before(deadline) {
+		<-time.After(time.Duration(time.Millisecond * 10))
+
+		err = loader.LoadSourcesWithCaching("TestKVSource_EtcdCompacted", &config, primary, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// assert
+	if config.IntValue != 789 {
+		t.Errorf("expected: %d but got %d", 789, config.IntValue)
+	}
+}
@@ -0,0 +1,320 @@
+package transfig_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestLoader_ZeroValue(t *testing.T) {
+	// arrange
+	var loader transfig.Loader
+	var actualConfig complex
+
+	// act
+	err := loader.Load("complex.json", "dev", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+
+	if loader.ReloadPollingInterval() != transfig.DefaultReloadPollingInterval {
+		t.Errorf("expected polling interval %s but got %s", transfig.DefaultReloadPollingInterval, loader.ReloadPollingInterval())
+	}
+}
+
+func TestLoader_Sources(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{
+		Sources: []transfig.Source{
+			transfig.BytesSource([]byte(`{ "intValue": 789 }`)),
+		},
+	}
+
+	err := os.WriteFile("complex.test.json", []byte(`{ "intValue": 456, "stringValue": "Hello world 2" }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("complex.test.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var actualConfig complex
+
+	// act
+	err = loader.Load("complex.json", "test", &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actualConfig.StringValue != "Hello world 2" {
+		t.Errorf("StringValue: expected '%s', actual '%s'", "Hello world 2", actualConfig.StringValue)
+	}
+
+	if actualConfig.IntValue != 789 {
+		t.Errorf("IntValue: expected %d, actual %d", 789, actualConfig.IntValue)
+	}
+}
+
+func TestLoader_IndependentCaches(t *testing.T) {
+	// arrange
+	fastLoader := &transfig.Loader{}
+	fastLoader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer fastLoader.Close()
+
+	slowLoader := &transfig.Loader{}
+	slowLoader.SetReloadPollingInterval(time.Duration(time.Hour))
+	defer slowLoader.Close()
+
+	// files in an fs.FS can't be watched, so changes are only found by polling
+	modTime := time.Now()
+	fsys := &lockedFS{files: fstest.MapFS{}}
+	fsys.set("superfluousFields.json", `{ "intValue": 1 }`, modTime)
+
+	var fastConfig, slowConfig superfluousFields
+
+	err := fastLoader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &fastConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = slowLoader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &slowConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys.set("superfluousFields.json", `{ "intValue": 2 }`, modTime.Add(time.Second))
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	err = fastLoader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &fastConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = slowLoader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &slowConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if fastConfig.IntValue != 2 {
+		t.Errorf("fast loader: expected: %d but got %d", 2, fastConfig.IntValue)
+	}
+
+	if slowConfig.IntValue != 1 {
+		t.Errorf("slow loader: expected: %d but got %d", 1, slowConfig.IntValue)
+	}
+}
+
+func TestConfig_WithLoader(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{
+		Sources: []transfig.Source{
+			transfig.BytesSource([]byte(`{ "intValue": 789 }`)),
+		},
+	}
+
+	config := transfig.NewConfig[complex]("complex.json", "dev").WithLoader(loader)
+
+	// act
+	actualConfig, err := config.Load()
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actualConfig.IntValue != 789 {
+		t.Errorf("IntValue: expected %d, actual %d", 789, actualConfig.IntValue)
+	}
+}
+
+func TestLoader_PollsEachConfigIndependently(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	errs := make(chan error, 10)
+	stop := loader.NotifyErrors(errs)
+	defer stop()
+
+	failing := &memorySource{}
+	failing.set(`{ "intValue": 1 }`)
+	changing := &memorySource{}
+	changing.set(`{ "intValue": 1 }`)
+
+	// load several configs, so the failing one isn't always polled last
+	var config superfluousFields
+	for i := 0; i < 5; i++ {
+		err := loader.LoadSourcesWithCaching(fmt.Sprint("failing", i), &config, failing)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := loader.LoadSourcesWithCaching("changing", &config, changing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	failing.fail(fs.ErrPermission)
+	changing.set(`{ "intValue": 2 }`)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	// assert
+	err = loader.LoadSourcesWithCaching("changing", &config, changing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, config.IntValue)
+	}
+
+	err = loader.LoadSourcesWithCaching("failing0", &config, failing)
+	if err != nil {
+		t.Fatalf("expected the last good config but got error: %s", err)
+	}
+
+	if config.IntValue != 1 {
+		t.Errorf("expected: %d but got %d", 1, config.IntValue)
+	}
+
+	// each failing config is reported once, rather than every time it's polled
+	if len(errs) != 5 {
+		t.Errorf("expected: %d errors but got %d", 5, len(errs))
+	}
+
+	for len(errs) > 0 {
+		err := <-errs
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("expected a permission error but got: %s", err)
+		}
+	}
+}
+
+func TestLoader_DeletedPrimaryIsEvicted(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	errs := make(chan error, 1)
+	stop := loader.NotifyErrors(errs)
+	defer stop()
+
+	fsys := &lockedFS{files: fstest.MapFS{}}
+	fsys.set("superfluousFields.json", `{ "intValue": 1 }`, time.Now())
+
+	var config superfluousFields
+	err := loader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	fsys.remove("superfluousFields.json")
+
+	// assert
+	select {
+	case err := <-errs:
+		if !errors.Is(err, transfig.ErrPrimaryConfigFileNotExist) {
+			t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload error")
+	}
+
+	err = loader.LoadWithCachingFS(fsys, "superfluousFields.json", "test", &config)
+	if err != transfig.ErrPrimaryConfigFileNotExist {
+		t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
+	}
+}
+
+// slowSource is a memorySource whose reads don't finish until it's released
+type slowSource struct {
+	memorySource
+	reading chan struct{}
+	release chan struct{}
+	reads   int32
+}
+
+func (s *slowSource) Read() ([]byte, error) {
+	if atomic.AddInt32(&s.reads, 1) == 1 {
+		close(s.reading)
+	}
+	<-s.release
+	return s.memorySource.Read()
+}
+
+func TestLoader_SlowLoadDoesNotBlockOtherKeys(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	defer loader.Close()
+
+	slow := &slowSource{reading: make(chan struct{}), release: make(chan struct{})}
+	slow.set(`{ "intValue": 1 }`)
+	fast := &memorySource{}
+	fast.set(`{ "intValue": 2 }`)
+
+	slowLoads := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var config superfluousFields
+			slowLoads <- loader.LoadSourcesWithCaching("slow", &config, slow)
+		}()
+	}
+	<-slow.reading
+
+	// act
+	loaded := make(chan error, 1)
+	go func() {
+		var config superfluousFields
+		loaded <- loader.LoadSourcesWithCaching("fast", &config, fast)
+	}()
+
+	// assert
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for a config while another was loading")
+	}
+
+	close(slow.release)
+	for i := 0; i < 2; i++ {
+		err := <-slowLoads
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// callers loading the same key share one load
+	if reads := atomic.LoadInt32(&slow.reads); reads != 1 {
+		t.Errorf("expected: %d reads but got %d", 1, reads)
+	}
+}
@@ -1,6 +1,7 @@
 package transfig_test
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"reflect"
@@ -68,3 +69,11 @@ func TestLoad_PrimaryFileNotExist(t *testing.T) {
 		t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
 	}
 }
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
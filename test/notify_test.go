@@ -0,0 +1,309 @@
+package transfig_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// writeChangedFile writes data to path, making sure its modification time changes, however coarse it is.
+func writeChangedFile(t *testing.T, path, data string) {
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Second)
+	err = os.Chtimes(path, future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoader_Subscribe(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer loader.Close()
+
+	err := copyFile("_TestLoader_Subscribe.json", "complex.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("_TestLoader_Subscribe.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	events := make(chan transfig.ReloadEvent, 1)
+	unsubscribe := loader.Subscribe(func(event transfig.ReloadEvent) {
+		events <- event
+	})
+	defer unsubscribe()
+
+	var config complex
+	err = loader.LoadWithCaching("_TestLoader_Subscribe.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	var changedConfig complex
+	err = transfig.Load("complex.json", "test", &changedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changedConfig.StringValue = "Hello world 2"
+	changedConfig.ObjectValue.ObjectValue.IntValue = 456
+	changedConfig.SliceValueInts = append(changedConfig.SliceValueInts, 4)
+	changedConfig.SliceValueObjects[1].StringValue = "Hello world 2"
+	writeChangedFile(t, "_TestLoader_Subscribe.json", mustMarshal(t, changedConfig))
+
+	// assert
+	select {
+	case event := <-events:
+		key, ok := event.Key.(transfig.FileKey)
+		if !ok || key.Path != "_TestLoader_Subscribe.json" || key.Environment != "test" {
+			t.Errorf("unexpected event key: %#v", event.Key)
+		}
+
+		if event.Old.(complex).StringValue != "Hello world" {
+			t.Errorf("Old.StringValue: expected '%s', actual '%s'", "Hello world", event.Old.(complex).StringValue)
+		}
+
+		if event.New.(complex).StringValue != "Hello world 2" {
+			t.Errorf("New.StringValue: expected '%s', actual '%s'", "Hello world 2", event.New.(complex).StringValue)
+		}
+
+		expectedChanged := []string{
+			"stringValue",
+			"sliceValueInts",
+			"objectValue.objectValue.intValue",
+			"sliceValueObjects[1].stringValue",
+		}
+
+		if !reflect.DeepEqual(expectedChanged, event.Changed) {
+			t.Errorf("expected changed paths %v, actual %v", expectedChanged, event.Changed)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload event")
+	}
+}
+
+func TestLoader_Notify(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer loader.Close()
+
+	err := os.WriteFile("TestLoader_Notify.json", []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("TestLoader_Notify.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	events := make(chan transfig.ReloadEvent, 1)
+	stop := loader.Notify(events)
+	defer stop()
+
+	config := transfig.NewConfig[superfluousFields]("TestLoader_Notify.json", "test").WithLoader(loader)
+	_, err = config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typedEvents := make(chan superfluousFields, 1)
+	unsubscribe := config.OnReload(func(oldConfig, newConfig superfluousFields, changed []string) {
+		typedEvents <- newConfig
+	})
+	defer unsubscribe()
+
+	// act
+	writeChangedFile(t, "TestLoader_Notify.json", `{ "intValue": 2 }`)
+
+	// assert
+	select {
+	case event := <-events:
+		if event.Key != config {
+			t.Errorf("unexpected event key: %#v", event.Key)
+		}
+
+		if !reflect.DeepEqual([]string{"intValue"}, event.Changed) {
+			t.Errorf("expected changed paths %v, actual %v", []string{"intValue"}, event.Changed)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload event")
+	}
+
+	select {
+	case newConfig := <-typedEvents:
+		if newConfig.IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, newConfig.IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for typed reload event")
+	}
+
+	if config.Current().IntValue != 2 {
+		t.Errorf("Current: expected: %d but got %d", 2, config.Current().IntValue)
+	}
+}
+
+func TestLoader_ReloadErrorKeepsLastGoodConfig(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+	defer loader.Close()
+
+	err := os.WriteFile("TestLoader_ReloadError.json", []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		err = os.Remove("TestLoader_ReloadError.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	errs := make(chan error, 1)
+	stop := loader.NotifyErrors(errs)
+	defer stop()
+
+	var config superfluousFields
+	err = loader.LoadWithCaching("TestLoader_ReloadError.json", "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	writeChangedFile(t, "TestLoader_ReloadError.json", `{ "intValue": `)
+
+	// assert
+	select {
+	case err := <-errs:
+		reloadErr, ok := err.(*transfig.ReloadError)
+		if !ok {
+			t.Fatalf("expected a *transfig.ReloadError but got %T", err)
+		}
+
+		key, ok := reloadErr.Key.(transfig.FileKey)
+		if !ok || key.Path != "TestLoader_ReloadError.json" {
+			t.Errorf("unexpected error key: %#v", reloadErr.Key)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload error")
+	}
+
+	var lastGoodConfig superfluousFields
+	err = loader.LoadWithCaching("TestLoader_ReloadError.json", "test", &lastGoodConfig)
+	if err != nil {
+		t.Fatalf("expected the last good config but got error: %s", err)
+	}
+
+	if lastGoodConfig.IntValue != 1 {
+		t.Errorf("expected: %d but got %d", 1, lastGoodConfig.IntValue)
+	}
+
+	// the config is reloaded once the file is fixed
+	writeChangedFile(t, "TestLoader_ReloadError.json", `{ "intValue": 2 }`)
+	<-time.After(time.Duration(time.Millisecond * 500))
+
+	var fixedConfig superfluousFields
+	err = loader.LoadWithCaching("TestLoader_ReloadError.json", "test", &fixedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fixedConfig.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, fixedConfig.IntValue)
+	}
+}
+
+func TestLoader_SubscribersGetCopies(t *testing.T) {
+	// arrange
+	type namesConfig struct {
+		Names []string `json:"names"`
+	}
+
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	source := &memorySource{}
+	source.set(`{ "names": ["a"] }`)
+
+	events := make(chan transfig.ReloadEvent, 1)
+	unsubscribe := loader.Subscribe(func(event transfig.ReloadEvent) {
+		event.New.(namesConfig).Names[0] = "MUTATED"
+		events <- event
+	})
+	defer unsubscribe()
+
+	var config namesConfig
+	err := loader.LoadSourcesWithCaching("TestLoader_SubscribersGetCopies", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	source.set(`{ "names": ["b"] }`)
+
+	select {
+	case <-events:
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload event")
+	}
+
+	// assert
+	err = loader.LoadSourcesWithCaching("TestLoader_SubscribersGetCopies", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Names[0] != "b" {
+		t.Errorf("expected: %s but got %s", "b", config.Names[0])
+	}
+}
+
+func TestLoader_SubscribeIgnoresUnchangedReloads(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	source := &memorySource{}
+	source.set(`{ "intValue": 1 }`)
+
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	var config superfluousFields
+	err := loader.LoadSourcesWithCaching("TestLoader_SubscribeIgnoresUnchangedReloads", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act: the source's version changes, but its contents don't
+	source.set(`{ "intValue": 1 }`)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	// assert
+	if len(events) != 0 {
+		t.Errorf("expected: %d reloads but got %d", 0, len(events))
+	}
+}
@@ -0,0 +1,97 @@
+package transfig_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sironfoot/transfig"
+)
+
+func TestLoadReader_WithEnvironment(t *testing.T) {
+	// arrange
+	primary, err := os.Open("complex.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	environment := strings.NewReader(`
+    {
+        // comments are stripped, just like files
+        "stringValue": "Hello world 2",
+        "objectValue": {
+            "intValue": 456
+        }
+    }`)
+
+	var actualConfig complex
+
+	// act
+	err = transfig.LoadReader(primary, environment, &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.StringValue = "Hello world 2"
+	expected.ObjectValue.IntValue = 456
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestLoadReader_NoEnvironment(t *testing.T) {
+	// arrange
+	primary, err := os.Open("withComments.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	var actualConfig complex
+
+	// act
+	err = transfig.LoadReader(primary, nil, &actualConfig)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expectedConfig, actualConfig)
+	}
+}
+
+func TestLoadBytes_NonPointer(t *testing.T) {
+	var configuration complex
+
+	err := transfig.LoadBytes([]byte(`{}`), nil, configuration)
+
+	// assert
+	if err != transfig.ErrConfigDataNotPointer {
+		t.Errorf("should have returned error: %s", transfig.ErrConfigDataNotPointer)
+	}
+}
+
+func TestLoadBytes_InvalidEnvironment(t *testing.T) {
+	// arrange
+	var configuration complex
+
+	// act
+	err := transfig.LoadBytes([]byte(`{ "intValue": 1 }`), []byte(`{ "intValue": `), &configuration)
+
+	// assert
+	if err == nil {
+		t.Fatal("expected an error for an invalid environment document")
+	}
+
+	if !strings.Contains(err.Error(), "environment") {
+		t.Errorf("expected error to mention the environment document, got: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package transfig_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+type schemaConfig struct {
+	Name     string            `json:"name" transfig:"required,nonempty" description:"The name of the app"`
+	Level    string            `json:"level" default:"info" transfig:"required,oneof=debug info"`
+	Port     int               `json:"port" transfig:"min=1,max=65535"`
+	Timeout  time.Duration     `json:"timeout" default:"30s"`
+	Debug    bool              `json:"debug"`
+	Hosts    []string          `json:"hosts" transfig:"max=3"`
+	Labels   map[string]string `json:"labels"`
+	Endpoint string            `json:"endpoint" transfig:"url"`
+	Ignored  string            `json:"-"`
+	Database *schemaDatabase   `json:"database"`
+	schemaEmbedded
+}
+
+type schemaDatabase struct {
+	Host string `json:"host" transfig:"required"`
+}
+
+type schemaEmbedded struct {
+	Region string `json:"region"`
+}
+
+func TestSchema(t *testing.T) {
+	// arrange
+	var config schemaConfig
+
+	// act
+	data, err := transfig.Schema(&config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "schemaConfig",
+  "type": "object",
+  "properties": {
+    "name": {
+      "description": "The name of the app",
+      "type": "string",
+      "minLength": 1
+    },
+    "level": {
+      "type": "string",
+      "default": "info",
+      "enum": [
+        "debug",
+        "info"
+      ]
+    },
+    "port": {
+      "type": "integer",
+      "minimum": 1,
+      "maximum": 65535
+    },
+    "timeout": {
+      "type": "integer",
+      "default": 30000000000
+    },
+    "debug": {
+      "type": "boolean"
+    },
+    "hosts": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      },
+      "maxItems": 3
+    },
+    "labels": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "string"
+      }
+    },
+    "endpoint": {
+      "type": "string",
+      "format": "uri"
+    },
+    "database": {
+      "type": "object",
+      "properties": {
+        "host": {
+          "type": "string"
+        }
+      },
+      "required": [
+        "host"
+      ]
+    },
+    "region": {
+      "type": "string"
+    }
+  },
+  "required": [
+    "name"
+  ]
+}`
+
+	if string(data) != expected {
+		t.Errorf("expected schema:\n%s\n\nActual:\n%s", expected, data)
+	}
+}
+
+func TestEnvironmentSchema(t *testing.T) {
+	// arrange
+	var config schemaConfig
+
+	// act
+	data, err := transfig.EnvironmentSchema(config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), `"required"`) {
+		t.Errorf("expected every property to be optional, got:\n%s", data)
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type interface{} `json:"type"`
+		} `json:"properties"`
+	}
+
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{"integer", "string"}
+	if !reflect.DeepEqual(expected, schema.Properties["timeout"].Type) {
+		t.Errorf("expected: %v but got %v", expected, schema.Properties["timeout"].Type)
+	}
+}
+
+func TestSchema_NotAStruct(t *testing.T) {
+	// act
+	_, err := transfig.Schema(map[string]string{})
+
+	// assert
+	if err == nil {
+		t.Error("expected an error for a type that isn't a struct")
+	}
+}
@@ -0,0 +1,182 @@
+package transfig_test
+
+import (
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// memorySource is an in-memory Source whose contents can be changed by a test.
+type memorySource struct {
+	mu      sync.Mutex
+	data    []byte
+	version int
+	err     error
+	changed func()
+}
+
+func (m *memorySource) Read() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.data == nil {
+		return nil, fs.ErrNotExist
+	}
+	return m.data, nil
+}
+
+func (m *memorySource) Version() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return "", m.err
+	}
+	return fmt.Sprint(m.version), nil
+}
+
+// fail makes the source return err until it is set again
+func (m *memorySource) fail(err error) {
+	m.mu.Lock()
+	m.err = err
+	m.mu.Unlock()
+}
+
+func (m *memorySource) set(data string) {
+	m.mu.Lock()
+	m.data = []byte(data)
+	m.err = nil
+	m.version++
+	changed := m.changed
+	m.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+}
+
+// watchedSource is a memorySource that reports its own changes.
+type watchedSource struct {
+	memorySource
+}
+
+func (w *watchedSource) Watch(version string, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.changed = changed
+	return stop, nil
+}
+
+func TestLoadSources_Layers(t *testing.T) {
+	// arrange
+	missing := &memorySource{}
+	override := &memorySource{}
+	override.set(`{ "stringValue": "Hello world 3", "objectValue": { "intValue": 789 } }`)
+
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadSources(&actualConfig,
+		transfig.FileSource("complex.json"),
+		missing,
+		transfig.BytesSource([]byte(`{ "stringValue": "Hello world 2", "intValue": 456 }`)),
+		override,
+	)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedConfig
+	expected.StringValue = "Hello world 3"
+	expected.IntValue = 456
+	expected.ObjectValue.IntValue = 789
+
+	if !reflect.DeepEqual(expected, actualConfig) {
+		t.Errorf("expected and actual config are different.\nExpected:\n%v\n\nActual:\n%v", expected, actualConfig)
+	}
+}
+
+func TestLoadSources_PrimaryNotExist(t *testing.T) {
+	// arrange
+	var actualConfig complex
+
+	// act
+	err := transfig.LoadSources(&actualConfig, &memorySource{}, transfig.FileSource("complex.json"))
+
+	// assert
+	if err != transfig.ErrPrimaryConfigFileNotExist {
+		t.Errorf("err expected: \"%s\" but got: \"%s\"", transfig.ErrPrimaryConfigFileNotExist, err)
+	}
+}
+
+func TestLoadSourcesWithCaching_PolledVersion(t *testing.T) {
+	defaultDuration := transfig.ReloadPollingInterval
+	defer func() {
+		transfig.SetReloadPollingInterval(defaultDuration)
+	}()
+
+	// arrange
+	transfig.SetReloadPollingInterval(time.Duration(time.Millisecond * 100))
+
+	override := &memorySource{}
+	override.set(`{ "intValue": 1 }`)
+
+	var config complex
+	err := transfig.LoadSourcesWithCaching("TestLoadSourcesWithCaching_PolledVersion", &config,
+		transfig.FileSource("complex.json"), override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	override.set(`{ "intValue": 2 }`)
+	<-time.After(time.Duration(time.Millisecond * 300))
+
+	err = transfig.LoadSourcesWithCaching("TestLoadSourcesWithCaching_PolledVersion", &config,
+		transfig.FileSource("complex.json"), override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, config.IntValue)
+	}
+}
+
+func TestLoadSourcesWithCaching_Watched(t *testing.T) {
+	// arrange
+	override := &watchedSource{}
+	override.set(`{ "intValue": 1 }`)
+
+	var config complex
+	err := transfig.LoadSourcesWithCaching("TestLoadSourcesWithCaching_Watched", &config,
+		transfig.FileSource("complex.json"), override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	override.set(`{ "intValue": 2 }`)
+
+	err = transfig.LoadSourcesWithCaching("TestLoadSourcesWithCaching_Watched", &config,
+		transfig.FileSource("complex.json"), override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 2 {
+		t.Errorf("expected: %d but got %d", 2, config.IntValue)
+	}
+}
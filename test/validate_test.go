@@ -0,0 +1,385 @@
+package transfig_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+type validatedConfig struct {
+	Name     string            `json:"name" transfig:"required"`
+	Level    string            `json:"level" transfig:"oneof=debug info warn"`
+	PageSize int               `json:"pageSize" transfig:"min=1,max=100"`
+	Timeout  time.Duration     `json:"timeout" transfig:"max=1m"`
+	Version  string            `json:"version" transfig:"regexp=^v[0-9]+(,[0-9]+)?$"`
+	Endpoint string            `json:"endpoint" transfig:"url"`
+	Listen   string            `json:"listen" transfig:"hostport"`
+	Tags     []string          `json:"tags" transfig:"nonempty,max=2"`
+	Servers  []validatedServer `json:"servers"`
+	Database *validatedServer  `json:"database"`
+}
+
+type validatedServer struct {
+	Host string `json:"host" transfig:"required,hostport"`
+}
+
+func TestValidate_Valid(t *testing.T) {
+	// arrange
+	// the slashes are escaped, so the URL isn't mistaken for a comment
+	data := []byte(`{
+		"name": "app",
+		"level": "info",
+		"pageSize": 20,
+		"timeout": 30000000000,
+		"version": "v1,2",
+		"endpoint": "https:\/\/example.com\/config",
+		"listen": ":8080",
+		"tags": ["a"],
+		"servers": [{ "host": "localhost:80" }]
+	}`)
+
+	var config validatedConfig
+
+	// act
+	err := transfig.LoadBytes(data, nil, &config)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate_AllViolations(t *testing.T) {
+	// arrange
+	data := []byte(`{
+		"level": "verbose",
+		"pageSize": 0,
+		"version": "1",
+		"endpoint": "example.com",
+		"listen": "localhost",
+		"tags": ["a", "b", "c"],
+		"servers": [{ "host": "localhost:80" }, { "host": "" }],
+		"database": { "host": "db:99999" }
+	}`)
+	envData := []byte(`{ "timeout": "2m" }`)
+
+	var config validatedConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	expected := []transfig.Violation{
+		{Path: "name", Rule: "required", Message: "is required"},
+		{Path: "level", Rule: "oneof=debug info warn", Message: `must be one of debug, info, warn, not "verbose"`},
+		{Path: "pageSize", Rule: "min=1", Message: "must be at least 1"},
+		{Path: "timeout", Rule: "max=1m", Message: "must be at most 1m"},
+		{Path: "version", Rule: "regexp=^v[0-9]+(,[0-9]+)?$", Message: "must match ^v[0-9]+(,[0-9]+)?$"},
+		{Path: "endpoint", Rule: "url", Message: `must be an absolute URL, not "example.com"`},
+		{Path: "listen", Rule: "hostport", Message: `must be a host and port, not "localhost"`},
+		{Path: "tags", Rule: "max=2", Message: "must have a length of at most 2"},
+		{Path: "servers[1].host", Rule: "required", Message: "is required"},
+		{Path: "database.host", Rule: "hostport", Message: `must be a host and port, not "db:99999"`},
+	}
+
+	if !reflect.DeepEqual(expected, validationErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, validationErr.Violations)
+	}
+
+	if !strings.HasPrefix(err.Error(), "config: invalid config: name is required; level must be one of") {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
+
+func TestValidate_AfterEnvironment(t *testing.T) {
+	// arrange
+	data := []byte(`{ "name": "app", "tags": ["a"], "pageSize": 0 }`)
+	envData := []byte(`{ "pageSize": 10 }`)
+
+	var config validatedConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	if err != nil {
+		t.Errorf("expected the environment config to make the config valid, but got: %s", err)
+	}
+}
+
+func TestValidate_InvalidTag(t *testing.T) {
+	// arrange
+	type badConfig struct {
+		Value string `json:"value" transfig:"min=a"`
+	}
+
+	var config badConfig
+
+	// act
+	err := transfig.LoadBytes([]byte(`{ "value": "a" }`), nil, &config)
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), "invalid transfig tag on transfig_test.badConfig.Value") {
+		t.Errorf("expected an invalid tag error but got: %v", err)
+	}
+}
+
+func TestValidate_StringLengthInCharacters(t *testing.T) {
+	// arrange
+	type namedConfig struct {
+		Name string `json:"name" transfig:"min=2,max=4"`
+	}
+
+	var config namedConfig
+
+	// act: 4 characters, but 8 bytes
+	err := transfig.LoadBytes([]byte(`{ "name": "ÅÄÖÜ" }`), nil, &config)
+
+	// assert
+	if err != nil {
+		t.Errorf("expected the length to be counted in characters, but got: %s", err)
+	}
+}
+
+var errNoPorts = errors.New("at least one port must be open")
+
+type selfValidatedConfig struct {
+	MinPort int                   `json:"minPort"`
+	MaxPort int                   `json:"maxPort"`
+	Servers []selfValidatedServer `json:"servers"`
+}
+
+func (c *selfValidatedConfig) Validate() error {
+	if c.MinPort > c.MaxPort {
+		return errNoPorts
+	}
+	return nil
+}
+
+type selfValidatedServer struct {
+	Host   string `json:"host"`
+	Backup string `json:"backup"`
+}
+
+func (s selfValidatedServer) Validate() error {
+	if s.Host == s.Backup {
+		return errors.New("backup must be a different host")
+	}
+	return nil
+}
+
+func TestValidate_ValidatorInterface(t *testing.T) {
+	// arrange
+	data := []byte(`{
+		"minPort": 9000,
+		"maxPort": 8000,
+		"servers": [{ "host": "a", "backup": "b" }, { "host": "c", "backup": "c" }]
+	}`)
+
+	var config selfValidatedConfig
+
+	// act
+	err := transfig.LoadBytes(data, nil, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	if !errors.Is(err, errNoPorts) {
+		t.Errorf("expected the error to wrap the error returned by Validate, got: %s", err)
+	}
+
+	expected := "config: invalid config: servers[1] backup must be a different host; at least one port must be open"
+	if err.Error() != expected {
+		t.Errorf("err expected: \"%s\" but got: \"%s\"", expected, err)
+	}
+}
+
+func TestValidate_ReloadKeepsLastGoodConfig(t *testing.T) {
+	// arrange
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Millisecond * 50))
+	defer loader.Close()
+
+	errs := make(chan error, 1)
+	stop := loader.NotifyErrors(errs)
+	defer stop()
+
+	source := &memorySource{}
+	source.set(`{ "minPort": 8000, "maxPort": 9000 }`)
+
+	var config selfValidatedConfig
+	err := loader.LoadSourcesWithCaching("TestValidate_ReloadKeepsLastGoodConfig", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	source.set(`{ "minPort": 9000, "maxPort": 8000 }`)
+
+	// assert
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errNoPorts) {
+			t.Errorf("expected a validation error but got: %s", err)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for reload error")
+	}
+
+	err = loader.LoadSourcesWithCaching("TestValidate_ReloadKeepsLastGoodConfig", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.MinPort != 8000 {
+		t.Errorf("expected: %d but got %d", 8000, config.MinPort)
+	}
+}
+
+type secretConfig struct {
+	Name     string         `json:"name"`
+	Database secretDatabase `json:"database"`
+}
+
+type secretDatabase struct {
+	Host     string `json:"host"`
+	Password string `json:"password" transfig:"envRequired,forbidInPrimary"`
+	Key      string `json:"key" transfig:"envRequired"`
+}
+
+func TestValidate_EnvRequired(t *testing.T) {
+	// arrange
+	data := []byte(`{ "name": "app", "database": { "host": "db", "password": "", "key": "default" } }`)
+	envData := []byte(`{ "database": { "password": "secret" } }`)
+
+	var config secretConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	expected := []transfig.Violation{
+		{Path: "database.key", Rule: "envRequired", Message: "must be set by an environment config"},
+	}
+
+	if !reflect.DeepEqual(expected, validationErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, validationErr.Violations)
+	}
+}
+
+func TestValidate_ForbidInPrimary(t *testing.T) {
+	// arrange
+	data := []byte(`{ "name": "app", "database": { "host": "db", "password": "committed" } }`)
+	envData := []byte(`{ "database": { "password": "secret", "key": "secret" } }`)
+
+	var config secretConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	expected := []transfig.Violation{
+		{Path: "database.password", Rule: "forbidInPrimary", Message: "must not be set in the primary config"},
+	}
+
+	if !reflect.DeepEqual(expected, validationErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, validationErr.Violations)
+	}
+}
+
+func TestValidate_EnvRequiredFromSources(t *testing.T) {
+	// arrange
+	var config secretConfig
+
+	// act
+	err := transfig.LoadSources(&config,
+		transfig.BytesSource([]byte(`{ "name": "app" }`)),
+		transfig.BytesSource([]byte(`{ "database": { "key": "secret" } }`)),
+		transfig.BytesSource([]byte(`{ "database": { "password": "secret" } }`)),
+	)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Database.Password != "secret" || config.Database.Key != "secret" {
+		t.Errorf("expected the secrets to be set, got: %+v", config.Database)
+	}
+
+	if transfig.Validate(&config) != nil {
+		t.Errorf("Validate shouldn't check where values came from, got: %s", transfig.Validate(&config))
+	}
+}
+
+func TestValidate_EnvRequiredMatchesKeysExactly(t *testing.T) {
+	// arrange
+	data := []byte(`{ "name": "app", "database": { "key": "fromPrimary" } }`)
+	envData := []byte(`{ "database": { "password": "secret", "KEY": "x" } }`)
+
+	var config secretConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	expected := []transfig.Violation{
+		{Path: "database.key", Rule: "envRequired", Message: "must be set by an environment config"},
+	}
+
+	if !reflect.DeepEqual(expected, validationErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, validationErr.Violations)
+	}
+}
+
+func TestValidate_EnvRequiredIgnoresNull(t *testing.T) {
+	// arrange
+	data := []byte(`{ "name": "app" }`)
+	envData := []byte(`{ "database": { "password": "secret", "key": null } }`)
+
+	var config secretConfig
+
+	// act
+	err := transfig.LoadBytes(data, envData, &config)
+
+	// assert
+	var validationErr *transfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *transfig.ValidationError but got: %v", err)
+	}
+
+	expected := []transfig.Violation{
+		{Path: "database.key", Rule: "envRequired", Message: "must be set by an environment config"},
+	}
+
+	if !reflect.DeepEqual(expected, validationErr.Violations) {
+		t.Errorf("expected violations:\n%v\n\nActual:\n%v", expected, validationErr.Violations)
+	}
+}
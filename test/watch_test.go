@@ -0,0 +1,366 @@
+//go:build linux
+
+package transfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sironfoot/transfig"
+)
+
+// newWatchingLoader returns a Loader that effectively never polls, so
+// any reload must have come from watching the file system.
+func newWatchingLoader() *transfig.Loader {
+	loader := &transfig.Loader{}
+	loader.SetReloadPollingInterval(time.Duration(time.Hour))
+	return loader
+}
+
+func TestWatch_RenameAndReplace(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act: save the way many editors do, by writing a new file and renaming it over the old one
+	err = os.WriteFile(path+".swp", []byte(`{ "intValue": 2 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(path+".swp", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	select {
+	case event := <-events:
+		if event.New.(superfluousFields).IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, event.New.(superfluousFields).IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for the replaced file to be reloaded")
+	}
+}
+
+func TestWatch_EnvironmentFileCreated(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = os.WriteFile(filepath.Join(dir, "superfluousFields.test.json"), []byte(`{ "intValue": 2 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	select {
+	case event := <-events:
+		if event.New.(superfluousFields).IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, event.New.(superfluousFields).IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for the new environment file to be loaded")
+	}
+}
+
+func TestWatch_SymlinkSwap(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	for i, data := range []string{`{ "intValue": 1 }`, `{ "intValue": 2 }`} {
+		err := os.Mkdir(filepath.Join(dir, "v"+string(rune('1'+i))), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, "v"+string(rune('1'+i)), "superfluousFields.json"), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Symlink(filepath.Join("v1", "superfluousFields.json"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = os.Symlink(filepath.Join("v2", "superfluousFields.json"), path+".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	select {
+	case event := <-events:
+		if event.New.(superfluousFields).IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, event.New.(superfluousFields).IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for the swapped symlink to be reloaded")
+	}
+}
+
+func TestWatch_Debounce(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 0 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range []string{`{ "intValue"`, `: `, `5`, ` }`} {
+		_, err = file.WriteString(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-time.After(time.Duration(time.Millisecond * 10))
+	}
+
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(time.Duration(time.Second * 1))
+
+	// assert
+	if len(events) != 1 {
+		t.Fatalf("expected a burst of writes to trigger %d reload, but got %d", 1, len(events))
+	}
+
+	event := <-events
+	if event.New.(superfluousFields).IntValue != 5 {
+		t.Errorf("expected: %d but got %d", 5, event.New.(superfluousFields).IntValue)
+	}
+}
+
+func TestWatch_BusySiblingFile(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a log file in the same directory that's written to more often than the debounce
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	go func() {
+		defer close(stopped)
+
+		log, err := os.Create(filepath.Join(dir, "app.log"))
+		if err != nil {
+			return
+		}
+		defer log.Close()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Duration(time.Millisecond * 20)):
+				log.WriteString("request handled\n")
+			}
+		}
+	}()
+
+	// act
+	<-time.After(time.Duration(time.Millisecond * 200))
+	err = os.WriteFile(path, []byte(`{ "intValue": 2 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	select {
+	case event := <-events:
+		if event.New.(superfluousFields).IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, event.New.(superfluousFields).IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 3)):
+		t.Fatal("timed out waiting for the config file to be reloaded")
+	}
+}
+
+func TestWatch_CloseWaitsForWatchers(t *testing.T) {
+	// arrange
+	goroutines := runtime.NumGoroutine()
+
+	loader := newWatchingLoader()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config superfluousFields
+	err = loader.LoadWithCaching(path, "test", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	loader.Close()
+
+	// assert
+	if running := runtime.NumGoroutine(); running > goroutines {
+		t.Errorf("expected at most %d goroutines once closed, but got %d", goroutines, running)
+	}
+}
+
+// rewrittenSource is a watchable file source that's rewritten straight after it's read,
+// so the file changes after it's loaded but before it's being watched.
+type rewrittenSource struct {
+	transfig.WatchableSource
+	path    string
+	rewrite string
+}
+
+func (r *rewrittenSource) Read() ([]byte, error) {
+	data, err := r.WatchableSource.Read()
+	if err == nil && r.rewrite != "" {
+		os.WriteFile(r.path, []byte(r.rewrite), 0644)
+		r.rewrite = ""
+	}
+	return data, err
+}
+
+func TestWatch_ChangedBeforeWatching(t *testing.T) {
+	// arrange
+	loader := newWatchingLoader()
+	defer loader.Close()
+
+	events := make(chan transfig.ReloadEvent, 10)
+	defer loader.Notify(events)()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "superfluousFields.json")
+
+	err := os.WriteFile(path, []byte(`{ "intValue": 1 }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &rewrittenSource{
+		WatchableSource: transfig.FileSource(path).(transfig.WatchableSource),
+		path:            path,
+		rewrite:         `{ "intValue": 2 }`,
+	}
+
+	// act
+	var config superfluousFields
+	err = loader.LoadSourcesWithCaching("TestWatch_ChangedBeforeWatching", &config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if config.IntValue != 1 {
+		t.Errorf("expected: %d but got %d", 1, config.IntValue)
+	}
+
+	select {
+	case event := <-events:
+		if event.New.(superfluousFields).IntValue != 2 {
+			t.Errorf("expected: %d but got %d", 2, event.New.(superfluousFields).IntValue)
+		}
+	case <-time.After(time.Duration(time.Second * 2)):
+		t.Fatal("timed out waiting for the file changed before it was watched to be reloaded")
+	}
+}
@@ -0,0 +1,603 @@
+package transfig
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// tagName is the struct tag holding a field's validation rules, separated by commas, e.g.
+//
+//	Level    string `json:"level" transfig:"required,oneof=debug info warn"`
+//	PageSize int    `json:"pageSize" transfig:"min=1,max=100"`
+//
+// A regexp rule must come last, as its pattern may itself contain commas.
+const tagName = "transfig"
+
+// Validator is implemented by config types that check their own values, e.g. where one
+// setting depends on another. Validate is called on the config, and on every struct, slice
+// element and map value within it that implements Validator, once the config has loaded.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned when a loaded config breaks the validation rules in its
+// struct tags, or its Validate methods fail. It lists every problem, not just the first.
+type ValidationError struct {
+	Violations []Violation
+}
+
+// Violation is a validation rule broken by a config value.
+type Violation struct {
+	// Path is the JSON path of the value, e.g. "database.maxConnections" or "servers[1].host"
+	Path string
+
+	// Rule is the rule that was broken, as written in the struct tag, e.g. "max=100",
+	// or "Validate()" if the value's Validate method failed.
+	Rule string
+
+	// Message describes what's wrong with the value
+	Message string
+
+	// Err is the error returned by the value's Validate method, if that's what failed
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return "config: invalid config: " + formatViolations(e.Violations)
+}
+
+func formatViolations(violations []Violation) string {
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		if violation.Path == "" {
+			messages[i] = violation.Message
+		} else {
+			messages[i] = violation.Path + " " + violation.Message
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors returned by Validate methods, so they
+// can be found using errors.Is and errors.As.
+func (e *ValidationError) Unwrap() []error {
+	var errs []error
+	for _, violation := range e.Violations {
+		if violation.Err != nil {
+			errs = append(errs, violation.Err)
+		}
+	}
+	return errs
+}
+
+// Validate checks configData against the rules in the transfig struct tags of its fields,
+// and those of any structs it contains, and calls any Validate methods (see Validator),
+// returning a *ValidationError if any fail. Load, and every other function that loads a
+// config, calls Validate once the primary and environment configs have been merged.
+// The rules are:
+//
+//	required     the value must not be the zero value
+//	nonempty     a string must not be blank, and a slice or map must not be empty
+//	min=n        a number or duration must be at least n, a string must have at least n characters, and a slice or map at least n elements
+//	max=n        as min, but at most n
+//	oneof=a b c  the value must be one of the space separated values
+//	regexp=re    a string must match the regular expression re
+//	url          a string must be an absolute URL
+//	hostport     a string must be a host and port, e.g. "localhost:8080"
+//
+// Empty values are only checked by required, nonempty, min and max, so that optional
+// values can be left out.
+//
+// Two more rules keep secrets, such as encryption keys and production passwords, out of
+// the primary config file, which is usually committed to source control. They depend on
+// which config file each value came from, so they are checked when a config is loaded,
+// but not by Validate itself:
+//
+//	envRequired      the value must be set, to anything but null, by an environment config (or a later source)
+//	forbidInPrimary  the value must not be set to anything but an empty value in the primary config
+func Validate(configData interface{}) error {
+	return validate(configData, nil)
+}
+
+// configLayers holds the decoded primary and environment config documents a config was
+// loaded from, for the envRequired and forbidInPrimary rules.
+type configLayers struct {
+	primary      interface{}
+	environments []interface{}
+}
+
+func validate(configData interface{}, layers *configLayers) error {
+	v := validator{layers: layers}
+
+	err := v.validate("", reflect.ValueOf(configData))
+	if err != nil {
+		return err
+	}
+
+	if len(v.violations) > 0 {
+		return &ValidationError{Violations: v.violations}
+	}
+
+	return nil
+}
+
+type validator struct {
+	layers     *configLayers
+	violations []Violation
+}
+
+func (v *validator) validate(path string, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+
+		return v.validate(path, value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			fieldInfo := value.Type().Field(i)
+			if fieldInfo.PkgPath != "" {
+				continue
+			}
+
+			name := jsonFieldName(fieldInfo)
+			if name == "-" {
+				continue
+			}
+
+			// encoding/json flattens embedded structs into their parent
+			fieldPath := joinPath(path, name)
+			if fieldInfo.Anonymous && fieldInfo.Tag.Get("json") == "" {
+				fieldPath = path
+			}
+
+			rules, err := parseRules(fieldInfo.Tag.Get(tagName))
+			if err != nil {
+				return fmt.Errorf("config: invalid %s tag on %s.%s: %s", tagName, value.Type(), fieldInfo.Name, err)
+			}
+
+			for _, rule := range rules {
+				if rule.name == "envRequired" || rule.name == "forbidInPrimary" {
+					message := v.checkLayers(rule, fieldPath)
+					if message != "" {
+						v.violations = append(v.violations, Violation{
+							Path:    fieldPath,
+							Rule:    rule.text,
+							Message: message,
+						})
+					}
+					continue
+				}
+
+				message, err := rule.check(value.Field(i))
+				if err != nil {
+					return fmt.Errorf("config: invalid %s tag on %s.%s: %s", tagName, value.Type(), fieldInfo.Name, err)
+				}
+
+				if message != "" {
+					v.violations = append(v.violations, Violation{
+						Path:    fieldPath,
+						Rule:    rule.text,
+						Message: message,
+					})
+				}
+			}
+
+			err = v.validate(fieldPath, value.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			err := v.validate(fmt.Sprintf("%s[%d]", path, i), value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		for _, key := range keys {
+			err := v.validate(joinPath(path, fmt.Sprint(key.Interface())), value.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// a value's own Validate method is called once its contents have been validated
+	err := callValidate(value)
+	if err != nil {
+		v.violations = append(v.violations, Violation{
+			Path:    path,
+			Rule:    "Validate()",
+			Message: err.Error(),
+			Err:     err,
+		})
+	}
+
+	return nil
+}
+
+// callValidate calls value's Validate method, if it has one, including one with a pointer receiver.
+func callValidate(value reflect.Value) error {
+	if !value.IsValid() || !value.CanInterface() {
+		return nil
+	}
+
+	if validator, ok := value.Interface().(Validator); ok {
+		return validator.Validate()
+	}
+
+	if !reflect.PointerTo(value.Type()).Implements(reflect.TypeOf((*Validator)(nil)).Elem()) {
+		return nil
+	}
+
+	pointer := reflect.New(value.Type())
+	if value.CanAddr() {
+		pointer = value.Addr()
+	} else {
+		pointer.Elem().Set(value)
+	}
+
+	return pointer.Interface().(Validator).Validate()
+}
+
+type rule struct {
+	text string
+	name string
+	arg  string
+}
+
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+
+	for tag != "" {
+		var text string
+
+		tag = strings.TrimLeft(tag, " ")
+		if strings.HasPrefix(tag, "regexp=") {
+			text, tag = tag, ""
+		} else {
+			text, tag, _ = strings.Cut(tag, ",")
+			text = strings.TrimSpace(text)
+		}
+
+		name, arg, _ := strings.Cut(text, "=")
+
+		switch name {
+		case "":
+			continue
+		case "required", "nonempty", "url", "hostport", "envRequired", "forbidInPrimary":
+			if arg != "" {
+				return nil, fmt.Errorf("%s doesn't take a value", name)
+			}
+		case "min", "max", "oneof":
+			if arg == "" {
+				return nil, fmt.Errorf("%s needs a value", name)
+			}
+		case "regexp":
+			_, err := compileRegexp(arg)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule \"%s\"", name)
+		}
+
+		rules = append(rules, rule{text: text, name: name, arg: arg})
+	}
+
+	return rules, nil
+}
+
+// checkLayers checks an envRequired or forbidInPrimary rule against the config documents
+// the value at path was loaded from, returning a message if the rule is broken.
+func (v *validator) checkLayers(r rule, path string) string {
+	if v.layers == nil {
+		return ""
+	}
+
+	segments := splitPath(path)
+
+	if r.name == "forbidInPrimary" {
+		// encoding/json matches the primary config's field names case insensitively
+		value, found := lookupDocument(v.layers.primary, segments, true)
+		if found && !isEmptyJSON(value) {
+			return "must not be set in the primary config"
+		}
+		return ""
+	}
+
+	// environment configs are applied by matching field names exactly,
+	// and nulls are ignored, so neither counts as setting the value
+	for _, environment := range v.layers.environments {
+		if value, found := lookupDocument(environment, segments, false); found && value != nil {
+			return ""
+		}
+	}
+
+	return "must be set by an environment config"
+}
+
+// lookupDocument finds the value at path in a decoded JSON document. If foldCase
+// is set, keys are matched case insensitively, as encoding/json matches them.
+func lookupDocument(document interface{}, path []string, foldCase bool) (interface{}, bool) {
+	for _, segment := range path {
+		switch realDocument := document.(type) {
+		case map[string]interface{}:
+			value, found := realDocument[segment]
+			if !found && foldCase {
+				for key, keyValue := range realDocument {
+					if strings.EqualFold(key, segment) {
+						value, found = keyValue, true
+						break
+					}
+				}
+			}
+			if !found {
+				return nil, false
+			}
+			document = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(realDocument) {
+				return nil, false
+			}
+			document = realDocument[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return document, true
+}
+
+func isEmptyJSON(value interface{}) bool {
+	switch realValue := value.(type) {
+	case nil:
+		return true
+	case string:
+		return realValue == ""
+	case []interface{}:
+		return len(realValue) == 0
+	case map[string]interface{}:
+		return len(realValue) == 0
+	}
+	return false
+}
+
+// usesLayerRules reports whether a config type has any envRequired or forbidInPrimary
+// rules, so the config documents only need decoding again if it does.
+func usesLayerRules(configType reflect.Type) bool {
+	if uses, ok := layerRulesCache.Load(configType); ok {
+		return uses.(bool)
+	}
+
+	uses := findLayerRules(configType, map[reflect.Type]bool{})
+	layerRulesCache.Store(configType, uses)
+
+	return uses
+}
+
+var layerRulesCache sync.Map
+
+func findLayerRules(configType reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[configType] {
+		return false
+	}
+	seen[configType] = true
+
+	switch configType.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return findLayerRules(configType.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < configType.NumField(); i++ {
+			fieldInfo := configType.Field(i)
+			for _, text := range strings.Split(fieldInfo.Tag.Get(tagName), ",") {
+				text = strings.TrimSpace(text)
+				if text == "envRequired" || text == "forbidInPrimary" {
+					return true
+				}
+			}
+
+			if findLayerRules(fieldInfo.Type, seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// check returns a message describing how value breaks the rule, or "" if it doesn't.
+// It returns an error if the rule can't be applied to a value of its type.
+func (r rule) check(value reflect.Value) (string, error) {
+	switch r.name {
+	case "required":
+		if value.IsZero() {
+			return "is required", nil
+		}
+		return "", nil
+	case "nonempty":
+		return checkNonEmpty(value), nil
+	case "min", "max":
+		return r.checkRange(value)
+	}
+
+	// the remaining rules don't apply to missing values
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "", nil
+		}
+		value = value.Elem()
+	}
+
+	if value.IsZero() {
+		return "", nil
+	}
+
+	if r.name == "oneof" {
+		actual := fmt.Sprint(value.Interface())
+		for _, allowed := range strings.Fields(r.arg) {
+			if actual == allowed {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("must be one of %s, not \"%s\"", strings.Join(strings.Fields(r.arg), ", "), actual), nil
+	}
+
+	if value.Kind() != reflect.String {
+		return "", fmt.Errorf("%s can only be used on strings", r.name)
+	}
+	actual := value.String()
+
+	switch r.name {
+	case "regexp":
+		pattern, _ := compileRegexp(r.arg)
+		if !pattern.MatchString(actual) {
+			return fmt.Sprintf("must match %s", r.arg), nil
+		}
+	case "url":
+		parsed, err := url.Parse(actual)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Sprintf("must be an absolute URL, not \"%s\"", actual), nil
+		}
+	case "hostport":
+		_, port, err := net.SplitHostPort(actual)
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			return fmt.Sprintf("must be a host and port, not \"%s\"", actual), nil
+		}
+	}
+
+	return "", nil
+}
+
+func checkNonEmpty(value reflect.Value) string {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "must not be empty"
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		if strings.TrimSpace(value.String()) == "" {
+			return "must not be empty"
+		}
+	case reflect.Slice, reflect.Map, reflect.Array:
+		if value.Len() == 0 {
+			return "must not be empty"
+		}
+	default:
+		if value.IsZero() {
+			return "must not be empty"
+		}
+	}
+
+	return ""
+}
+
+func (r rule) checkRange(value reflect.Value) (string, error) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "", nil
+		}
+		value = value.Elem()
+	}
+
+	bound, err := r.parseBound(value)
+	if err != nil {
+		return "", err
+	}
+
+	var actual float64
+	var length bool
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = value.Float()
+	case reflect.String:
+		// counted in characters, as the JSON Schema minLength and maxLength are
+		actual = float64(utf8.RuneCountInString(value.String()))
+		length = true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		actual = float64(value.Len())
+		length = true
+	default:
+		return "", fmt.Errorf("%s can't be used on a %s", r.name, value.Type())
+	}
+
+	if (r.name == "min" && actual >= bound) || (r.name == "max" && actual <= bound) {
+		return "", nil
+	}
+
+	comparison := "at least"
+	if r.name == "max" {
+		comparison = "at most"
+	}
+
+	if length {
+		return fmt.Sprintf("must have a length of %s %s", comparison, r.arg), nil
+	}
+	return fmt.Sprintf("must be %s %s", comparison, r.arg), nil
+}
+
+// parseBound parses the value of a min or max rule. Durations may be written
+// as a duration string, e.g. "1m30s".
+func (r rule) parseBound(value reflect.Value) (float64, error) {
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(r.arg)
+		if err == nil {
+			return float64(duration), nil
+		}
+	}
+
+	bound, err := strconv.ParseFloat(r.arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, not \"%s\"", r.name, r.arg)
+	}
+
+	return bound, nil
+}
+
+var regexpCache sync.Map
+
+// compileRegexp compiles a regexp rule's pattern, reusing it for later validations
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := regexpCache.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexpCache.Store(pattern, compiled)
+
+	return compiled, nil
+}
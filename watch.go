@@ -0,0 +1,141 @@
+package transfig
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+)
+
+// watchDebounce is how long a watched directory must be quiet before a burst
+// of changes (e.g. an editor writing a file in several steps) is acted upon.
+const watchDebounce = time.Duration(time.Millisecond * 100)
+
+// watchMaxWait is the longest a burst of changes is left before it's acted upon,
+// so a directory that's never quiet doesn't stop changes being picked up.
+const watchMaxWait = time.Duration(time.Second)
+
+// watchedDir is a directory to watch for changes, along with the names of the entries in it
+// whose changes matter. names may be glob patterns. If names is nil, every change matters.
+type watchedDir struct {
+	path  string
+	names []string
+}
+
+// matches reports whether a change to the entry called name matters. An empty
+// name is a change to the directory itself, e.g. it being deleted.
+func (w watchedDir) matches(name string) bool {
+	if w.names == nil || name == "" {
+		return true
+	}
+
+	for _, pattern := range w.names {
+		if name == pattern {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+var errWatchUnsupported = errors.New("config: watching files is not supported on this platform")
+
+// watchSource watches dirs for changes, calling changed whenever the Version of source
+// differs from version after a burst of changes, including when it can no longer be read.
+// It's also compared once the dirs are being watched, so a change made after source was
+// loaded, but before then, isn't missed. The returned channel is closed once stop has been
+// closed and watching has stopped. Watching the containing directories, rather than the
+// files themselves, means files replaced by renaming over them (as many editors do) and
+// swapped symlinks are noticed too. It returns an error if dirs can't be watched, in which
+// case the source will be polled instead.
+func watchSource(source Source, version string, dirs []watchedDir, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	baseline := version
+
+	return watchDirs(dirs, stop, func() {
+		version := sourceVersion(context.Background(), source)
+		if version == baseline {
+			return
+		}
+
+		baseline = version
+		changed()
+	})
+}
+
+// watchedDirs returns the directories to watch for changes to path, including the
+// directory a symlinked path resolves to. Only changes to path itself, its symlink
+// target, or a Kubernetes style "..data" symlink it resolves through matter.
+func watchedDirs(path string) []watchedDir {
+	dirs := []watchedDir{{
+		path:  filepath.Dir(path),
+		names: []string{filepath.Base(path), "..data"},
+	}}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || resolved == path {
+		return dirs
+	}
+
+	if filepath.Dir(resolved) == dirs[0].path {
+		dirs[0].names = append(dirs[0].names, filepath.Base(resolved))
+	} else {
+		dirs = append(dirs, watchedDir{
+			path:  filepath.Dir(resolved),
+			names: []string{filepath.Base(resolved)},
+		})
+	}
+
+	return dirs
+}
+
+// includeDirs returns the directories holding files matched by include patterns.
+// Directories that are themselves globs can't be watched, so are skipped.
+func includeDirs(includes []string) []watchedDir {
+	var dirs []watchedDir
+	for _, include := range includes {
+		dir := filepath.Dir(include)
+		if !isGlob(dir) {
+			dirs = append(dirs, watchedDir{
+				path:  dir,
+				names: []string{filepath.Base(include)},
+			})
+		}
+	}
+	return dirs
+}
+
+// Watch watches the config file, and any files it includes, for changes.
+// Only files on the operating system's file system can be watched.
+func (c *configFile) Watch(version string, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	if _, ok := c.FS.(osFS); !ok {
+		return nil, errWatchUnsupported
+	}
+
+	c.mu.Lock()
+	dirs := append(watchedDirs(c.Path), includeDirs(c.includes)...)
+	c.mu.Unlock()
+
+	return watchSource(c, version, dirs, stop, changed)
+}
+
+// Watch watches the fragments directory, and any files its fragments include, for changes.
+// Only directories on the operating system's file system can be watched.
+func (f *fragmentsDir) Watch(version string, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	if _, ok := f.FS.(osFS); !ok {
+		return nil, errWatchUnsupported
+	}
+
+	f.mu.Lock()
+	dirs := append([]watchedDir{{path: f.Dir}}, includeDirs(f.includes)...)
+	f.mu.Unlock()
+
+	return watchSource(f, version, dirs, stop, changed)
+}
+
+// Watch watches the directory for changes, including the "..data" symlink being swapped.
+func (c *configMap) Watch(version string, stop <-chan struct{}, changed func()) (<-chan struct{}, error) {
+	return watchSource(c, version, []watchedDir{{path: c.Dir}}, stop, changed)
+}
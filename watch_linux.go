@@ -0,0 +1,144 @@
+//go:build linux
+
+package transfig
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watchDirs uses inotify to watch dirs, calling onChange once each burst of changes that
+// matter has died down, or watchMaxWait after the burst started if it carries on. onChange
+// is also called once as soon as dirs are being watched, before watchDirs returns, as changes
+// made before then have no events. It's never called concurrently. The returned channel is
+// closed once stop has been closed and both of the goroutines watching dirs have exited.
+func watchDirs(dirs []watchedDir, stop <-chan struct{}, onChange func()) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	watches := make(map[int32][]watchedDir, len(dirs))
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir.path, inotifyMask)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir.path, Err: err}
+		}
+
+		// the same directory may be watched for several names
+		watches[int32(wd)] = append(watches[int32(wd)], dir)
+	}
+
+	onChange()
+
+	// a non-blocking file is handled by the runtime's poller, so closing
+	// it unblocks the pending Read below
+	inotify := os.NewFile(uintptr(fd), "inotify")
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := inotify.Read(buf)
+			if err != nil {
+				return
+			}
+
+			if !matchesEvents(buf[:n], watches) {
+				continue
+			}
+
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// closing inotify stops the reader, which then closes events
+		defer func() {
+			for range events {
+			}
+		}()
+		defer inotify.Close()
+
+		// fire is nil unless a burst of changes is waiting to be acted upon
+		var debounce *time.Timer
+		var fire <-chan time.Time
+		var deadline time.Time
+
+		for {
+			select {
+			case <-stop:
+				if debounce != nil {
+					debounce.Stop()
+				}
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if fire == nil {
+					deadline = time.Now().Add(watchMaxWait)
+				} else {
+					debounce.Stop()
+				}
+
+				delay := watchDebounce
+				if remaining := time.Until(deadline); remaining < delay {
+					delay = remaining
+				}
+
+				debounce = time.NewTimer(delay)
+				fire = debounce.C
+			case <-fire:
+				fire = nil
+				onChange()
+			}
+		}
+	}()
+
+	return done, nil
+}
+
+// matchesEvents reports whether any of the inotify events in buf are for a change that matters
+func matchesEvents(buf []byte, watches map[int32][]watchedDir) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		if nameEnd > len(buf) {
+			return true
+		}
+
+		name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+		offset = nameEnd
+
+		// the kernel dropped events, so assume one of them mattered
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			return true
+		}
+
+		for _, dir := range watches[event.Wd] {
+			if dir.matches(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package transfig
+
+// watchDirs isn't supported on this platform, so config files are polled instead.
+func watchDirs(dirs []watchedDir, stop <-chan struct{}, onChange func()) (<-chan struct{}, error) {
+	return nil, errWatchUnsupported
+}